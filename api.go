@@ -2,14 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strconv"
 	"time"
 )
 
+const eyeAPIURL = "https://youtube.the-eye.eu/api/admin/requests"
+
 // AdminRequests is for the /api/admin/requests endpoint
 type AdminRequests struct {
 	Ok       bool   `json:"ok"`
@@ -27,97 +29,119 @@ type Payload struct {
 	VideoIds []string `json:"video_ids"`
 }
 
-func pushIDs(videoIDs []string) error {
+// eyeQueue is a WorkQueue backed by the youtube.the-eye.eu admin API
+type eyeQueue struct {
+	URL    string
+	Secret string
+	client *http.Client
+}
+
+func newEyeQueue(secret string) *eyeQueue {
+	return &eyeQueue{
+		URL:    eyeAPIURL,
+		Secret: secret,
+		client: &http.Client{
+			Timeout: time.Second * 10,
+		},
+	}
+}
+
+func (q *eyeQueue) sendIDs(ctx context.Context, method string, IDs []string) error {
 	data := new(Payload)
-	data.VideoIds = videoIDs
+	data.VideoIds = IDs
 	payloadBytes, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
 	body := bytes.NewReader(payloadBytes)
 
-	req, err := http.NewRequest("POST", "https://youtube.the-eye.eu/api/admin/requests", body)
+	req, err := http.NewRequest(method, q.URL, body)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("X-Secret", arguments.Secret)
+	req.Header.Set("X-Secret", q.Secret)
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := q.client.Do(req.WithContext(ctx))
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{URL: "the API", StatusCode: resp.StatusCode}
+	}
+
 	return nil
 }
 
-func markIDsArchived(IDs ...string) error {
-	data := new(Payload)
-	data.VideoIds = IDs
-	payloadBytes, err := json.Marshal(data)
-	if err != nil {
-		return err
-	}
-	body := bytes.NewReader(payloadBytes)
+// Push send new IDs to the API
+func (q *eyeQueue) Push(ctx context.Context, IDs ...string) error {
+	return q.sendIDs(ctx, "POST", IDs)
+}
 
-	req, err := http.NewRequest("PUT", "https://youtube.the-eye.eu/api/admin/requests", body)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("X-Secret", arguments.Secret)
-	req.Header.Set("Content-Type", "application/json")
+// Ack mark IDs as archived on the API
+func (q *eyeQueue) Ack(ctx context.Context, IDs ...string) error {
+	return q.sendIDs(ctx, "PUT", IDs)
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+// Tombstone mark the ID as archived on the API, which
+// has no other way to stop serving it
+func (q *eyeQueue) Tombstone(ctx context.Context, ID string, reason string) error {
+	return q.sendIDs(ctx, "PUT", []string{ID})
+}
+
+// Requeue send the IDs to the API again, as new IDs
+func (q *eyeQueue) Requeue(ctx context.Context, IDs ...string) error {
+	return q.sendIDs(ctx, "POST", IDs)
+}
 
+// Nack does nothing, the API will serve the ID again later
+func (q *eyeQueue) Nack(ctx context.Context, ID string, reason error) error {
 	return nil
 }
 
-func getID(secret string, offset, limit int) (IDs []string) {
-	URL := "https://youtube.the-eye.eu/api/admin/requests?" +
-		"offset=" + strconv.Itoa(offset) +
-		"&limit=" + strconv.Itoa(limit)
+func (q *eyeQueue) Close() error {
+	return nil
+}
 
-	spaceClient := http.Client{
-		Timeout: time.Second * 10,
-	}
+// Fetch get IDs to archive from the API, it never returns io.EOF
+func (q *eyeQueue) Fetch(ctx context.Context, limit int) (IDs []string, err error) {
+	URL := q.URL + "?" +
+		"offset=0" +
+		"&limit=" + strconv.Itoa(limit)
 
 	req, err := http.NewRequest(http.MethodGet, URL, nil)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	req.Header.Set("X-Secret", secret)
+	req.Header.Set("X-Secret", q.Secret)
+
+	res, err := q.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
 
-	res, getErr := spaceClient.Do(req)
-	if getErr != nil {
-		log.Fatal(getErr)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, &StatusError{URL: "the API", StatusCode: res.StatusCode}
 	}
 
-	body, readErr := ioutil.ReadAll(res.Body)
-	if readErr != nil {
-		log.Fatal(readErr)
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
 	}
 
 	requestResponse := AdminRequests{}
-	jsonErr := json.Unmarshal(body, &requestResponse)
-	if jsonErr != nil {
-		log.Println(jsonErr)
-		return nil
+	err = json.Unmarshal(body, &requestResponse)
+	if err != nil {
+		return nil, err
 	}
 
 	for _, response := range requestResponse.Requests {
 		IDs = append(IDs, response.VideoID)
 	}
 
-	if len(IDs) < 1 {
-		log.Println(jsonErr)
-		return nil
-	}
-
-	return IDs
+	return IDs, nil
 }
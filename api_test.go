@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEyeQueueStatus(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"ok":true,"requests":[]}`))
+	}))
+	defer server.Close()
+
+	q := newEyeQueue("secret")
+	q.URL = server.URL
+	ctx := context.Background()
+
+	if err := q.Ack(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Fetch(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status = range []int{http.StatusUnauthorized, http.StatusInternalServerError} {
+		err := q.Ack(ctx, "a")
+		if e, ok := err.(*StatusError); !ok || e.StatusCode != status {
+			t.Errorf("Ack() with status %d returned %v", status, err)
+		}
+		_, err = q.Fetch(ctx, 1)
+		if e, ok := err.(*StatusError); !ok || e.StatusCode != status {
+			t.Errorf("Fetch() with status %d returned %v", status, err)
+		}
+	}
+}
@@ -1,17 +1,23 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"time"
 )
 
-// Returns true if we should mark as archived
-func archiveID(ID string) (archived bool) {
+// Returns nil if we should mark as archived, and an *UnavailableError
+// if the video can't be watched, by anyone if it is Permanent
+func archiveID(ctx context.Context, ID string) error {
 
 	// Record start time
 	start := time.Now()
 
+	// Give up on this video once the deadline is reached
+	ctx, cancel := context.WithTimeout(ctx, arguments.Timeout)
+	defer cancel()
+
 	// Create custom logger for this job
 	f, err := os.OpenFile(logFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -29,50 +35,140 @@ func archiveID(ID string) (archived bool) {
 	// Set thumbnail URL
 	video.Thumbnail = "http://i3.ytimg.com/vi/" + ID + "/maxresdefault.jpg"
 
-	// Check if the files already exists
-	err = checkFiles(video)
+	// Check if the files already exists, when the manifest can be
+	// found without the metadata, archived videos are only fetched
+	// again in refresh mode
+	pathNeedsMetadata := templateNeedsMetadata(arguments.DirTemplate) ||
+		(!dirTemplateIsPerVideo() && templateNeedsMetadata(arguments.FileTemplate))
+	refresh := false
+	err = checkIndex(video)
+	if err == nil && !pathNeedsMetadata {
+		err = checkFiles(video)
+	}
 	if err != nil {
-		return true
+		if !arguments.Refresh {
+			return nil
+		}
+		refresh = true
 	}
 
-	// Generate path to store files
-	err = genPath(video)
+	// Get metadata from InnerTube, or from the HTML of the page
+	if arguments.InnerTube {
+		err = parseInnerTube(ctx, video)
+	} else {
+		err = parseHTML(ctx, video)
+	}
 	if err != nil {
-		workerLog.Fatalln(err)
+		workerLog.Println(err)
+
+		// Keep a record of why the video can't be archived
+		if unavailable, ok := err.(*UnavailableError); ok && unavailable.Permanent() {
+			tombstoneErr := writeTombstone(video, unavailable)
+			if tombstoneErr != nil {
+				workerLog.Println(tombstoneErr)
+			}
+		}
+		return err
+	}
+
+	if pathNeedsMetadata && !refresh {
+		err = checkFiles(video)
+		if err != nil {
+			if !arguments.Refresh {
+				return nil
+			}
+			refresh = true
+		}
 	}
 
-	// Get HTML of the page and parse it
-	err = parseHTML(video)
+	// Make the URLs of the formats usable
+	err = decipherFormats(ctx, video)
 	if err != nil {
 		workerLog.Println(err)
-		os.RemoveAll(video.Path)
-		return false
+		return err
+	}
+
+	// Define the final paths now that the metadata is known
+	renderPaths(video)
+
+	// Compare with the archived video when refreshing it
+	if refresh {
+		err = loadArchived(video)
+		if err != nil {
+			workerLog.Println(err)
+			refresh = false
+		}
+	}
+
+	// Generate the staging path to store files
+	err = genPath(video)
+	if err != nil {
+		workerLog.Fatalln(err)
 	}
 
 	// Fetch subtitles
-	err = fetchSubs(video)
+	err = fetchSubs(ctx, video)
 	if err != nil {
 		workerLog.Println(err)
-		os.RemoveAll(video.Path)
-		return false
+		removeFiles(video)
+		return err
+	}
+
+	// Don't write anything if we got canceled in the meantime
+	if err = ctx.Err(); err != nil {
+		workerLog.Println(err)
+		removeFiles(video)
+		return err
 	}
 
 	// Write metadata to files
 	err = writeFiles(video)
 	if err != nil {
 		workerLog.Println(err)
-		os.RemoveAll(video.Path)
-		return false
+		removeFiles(video)
+		return err
 	}
 
 	// Download the thumbnail
-	err = downloadThumbnail(video)
+	err = downloadThumbnail(ctx, video)
+	if err != nil {
+		workerLog.Println(err)
+		removeFiles(video)
+		return err
+	}
+
+	// Keep what changed since the previous capture
+	if refresh {
+		err = writeChanges(video)
+		if err != nil {
+			workerLog.Println(err)
+			removeFiles(video)
+			return err
+		}
+	}
+
+	// List the files with their checksums
+	err = writeManifest(video)
+	if err != nil {
+		workerLog.Println(err)
+		removeFiles(video)
+		return err
+	}
+
+	// Move the files to their final path
+	err = commitFiles(video)
+	if err != nil {
+		workerLog.Println(err)
+		removeFiles(video)
+		return err
+	}
+
+	// Remember where the video is, the archive is complete even if that fails
+	err = writeIndex(video.ID, video.Path+video.FileName+manifestExtension)
 	if err != nil {
 		workerLog.Println(err)
-		os.RemoveAll(video.Path)
-		return false
 	}
 
 	workerLog.Println("archiving completed in " + time.Since(start).String())
-	return true
+	return nil
 }
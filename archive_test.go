@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"image/jpeg"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchiveID(t *testing.T) {
+	defer useFixtures(t)()
+
+	for _, ID := range []string{legacyFixtureID, modernFixtureID} {
+		if err := archiveID(context.Background(), ID); err != nil {
+			t.Errorf("%s: %s", ID, err)
+			continue
+		}
+
+		dir := filepath.Join(arguments.Output, ID[:1], ID[:3], ID)
+		infos, err := filepath.Glob(filepath.Join(dir, ID+"_*_*.info.json"))
+		if err != nil || len(infos) != 1 {
+			t.Errorf("%s: info.json files = %q", ID, infos)
+			continue
+		}
+
+		data, err := ioutil.ReadFile(infos[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, ID+".info.json", data)
+
+		for _, pattern := range []string{"*.description", "*.status.json", "*.en.xml", "*.fr.xml", "*.manifest.json"} {
+			matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+			if len(matches) != 1 {
+				t.Errorf("%s: %s files = %q", ID, pattern, matches)
+			}
+		}
+
+		thumbnails, _ := filepath.Glob(filepath.Join(dir, "*.jpg"))
+		if len(thumbnails) != 1 {
+			t.Errorf("%s: thumbnails = %q", ID, thumbnails)
+			continue
+		}
+		f, err := os.Open(thumbnails[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = jpeg.DecodeConfig(f)
+		f.Close()
+		if err != nil {
+			t.Errorf("%s: invalid thumbnail: %s", ID, err)
+		}
+	}
+}
+
+func TestArchiveIDMissingPage(t *testing.T) {
+	defer useFixtures(t)()
+
+	ID := "missingVid0"
+	err := archiveID(context.Background(), ID)
+	if unavailable, ok := err.(*UnavailableError); !ok || unavailable.Reason != unavailableNotFound {
+		t.Fatalf("expected a not found error for a missing page, got %v", err)
+	}
+
+	// only the tombstone is written
+	dir := filepath.Join(arguments.Output, ID[:1], ID[:3], ID)
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 || files[0].Name() != ID+tombstoneExtension {
+		t.Errorf("files = %v", files)
+	}
+}
+
+func TestArchiveIDLeavesNothingStaged(t *testing.T) {
+	defer useFixtures(t)()
+
+	for _, ID := range []string{legacyFixtureID, "missingVid0"} {
+		archiveID(context.Background(), ID)
+
+		staged, _ := ioutil.ReadDir(stagingRoot())
+		if len(staged) != 0 {
+			t.Errorf("%s: %d directories left in staging", ID, len(staged))
+		}
+	}
+}
+
+func TestCleanStaging(t *testing.T) {
+	defer useFixtures(t)()
+
+	leftover := filepath.Join(stagingRoot(), legacyFixtureID+"-123", "partial.info.json")
+	if err := os.MkdirAll(filepath.Dir(leftover), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(leftover, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cleanStaging(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stagingRoot()); !os.IsNotExist(err) {
+		t.Errorf("%s was not removed", stagingRoot())
+	}
+}
+
+func TestArchiveIDSharedDirectory(t *testing.T) {
+	defer useFixtures(t)()
+	arguments.DirTemplate = "all"
+
+	for _, ID := range []string{legacyFixtureID, modernFixtureID} {
+		if err := archiveID(context.Background(), ID); err != nil {
+			t.Fatalf("%s: %s", ID, err)
+		}
+	}
+
+	infos, _ := filepath.Glob(filepath.Join(arguments.Output, "all", "*.info.json"))
+	if len(infos) != 2 {
+		t.Errorf("info.json files = %q", infos)
+	}
+}
+
+func TestArchiveIDManifest(t *testing.T) {
+	defer useFixtures(t)()
+
+	ID := legacyFixtureID
+	if err := archiveID(context.Background(), ID); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(arguments.Output, ID[:1], ID[:3], ID)
+	manifests, _ := filepath.Glob(filepath.Join(dir, "*.manifest.json"))
+	if len(manifests) != 1 {
+		t.Fatalf("manifests = %q", manifests)
+	}
+	manifest, err := readManifest(manifests[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// description, info.json, status.json, 2 subtitles and the thumbnail
+	if manifest.ID != ID || manifest.ToolVersion != version || len(manifest.Files) != 6 {
+		t.Errorf("manifest = %+v", manifest)
+	}
+	for _, artifact := range manifest.Files {
+		size, sum, err := hashFile(filepath.Join(dir, artifact.Name))
+		if err != nil || size != artifact.Size || sum != artifact.SHA256 || artifact.FetchedAt.IsZero() {
+			t.Errorf("%s doesn't match the manifest: %+v", artifact.Name, artifact)
+		}
+	}
+
+	// A complete video is skipped, an incomplete one is archived again
+	video := &Video{ID: ID}
+	if checkFiles(video) == nil {
+		t.Error("complete video not detected")
+	}
+
+	thumbnails, _ := filepath.Glob(filepath.Join(dir, "*.jpg"))
+	os.Remove(thumbnails[0])
+	if err := checkFiles(video); err != nil {
+		t.Errorf("incomplete video detected as archived: %s", err)
+	}
+	if err := archiveID(context.Background(), ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(thumbnails[0]); err != nil {
+		t.Error("missing thumbnail not archived again")
+	}
+}
+
+func TestArchiveIDIndex(t *testing.T) {
+	defer useFixtures(t)()
+	arguments.DirTemplate = "%(uploader)s/%(id)s"
+
+	ID := legacyFixtureID
+	if err := archiveID(context.Background(), ID); err != nil {
+		t.Fatal(err)
+	}
+	path, err := readIndex(ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest, err := readManifest(path); err != nil || manifest.ID != ID {
+		t.Fatalf("index points to %s: %v", path, err)
+	}
+
+	// the archived video is skipped without fetching its metadata
+	tripper := countingTripper{Paths: make(map[string]int)}
+	directTripper = tripper
+	if err := archiveID(context.Background(), ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(tripper.Paths) != 0 {
+		t.Errorf("requests for an archived video: %v", tripper.Paths)
+	}
+
+	// videos archived before the index get their entry back
+	os.RemoveAll(filepath.Join(arguments.Output, indexDirName))
+	directTripper = fixtureTripper{}
+	if err := archiveID(context.Background(), ID); err != nil {
+		t.Fatal(err)
+	}
+	if indexed, err := readIndex(ID); err != nil || indexed != path {
+		t.Errorf("index entry = %q, %v", indexed, err)
+	}
+}
+
+func TestArchiveIDKeepsExistingFiles(t *testing.T) {
+	defer useFixtures(t)()
+
+	// a new directory is readable by everyone
+	ID := modernFixtureID
+	if err := archiveID(context.Background(), ID); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(arguments.Output, ID[:1], ID[:3], ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("directory mode = %s", info.Mode().Perm())
+	}
+
+	// the files already there are kept
+	ID = legacyFixtureID
+	dir := filepath.Join(arguments.Output, ID[:1], ID[:3], ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	kept := []string{ID + tombstoneExtension, "notes.txt"}
+	for _, name := range kept {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := archiveID(context.Background(), ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range kept {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s was removed", name)
+		}
+	}
+	if manifests, _ := filepath.Glob(filepath.Join(dir, "*"+manifestExtension)); len(manifests) != 1 {
+		t.Errorf("manifests = %q", manifests)
+	}
+}
+
+func TestArchiveIDLegacyDirectory(t *testing.T) {
+	defer useFixtures(t)()
+
+	// a video archived before manifests existed
+	ID := legacyFixtureID
+	dir := filepath.Join(arguments.Output, ID[:1], ID[:3], ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	base := filepath.Join(dir, ID+"_Old_title")
+	files := map[string]string{
+		".info.json":   `{"id": "` + ID + `", "title": "Old title"}`,
+		".description": "old description",
+		".en.xml":      "<transcript/>",
+	}
+	for extension, content := range files {
+		if err := ioutil.WriteFile(base+extension, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tripper := countingTripper{Paths: make(map[string]int)}
+	directTripper = tripper
+	if err := archiveID(context.Background(), ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(tripper.Paths) != 0 {
+		t.Errorf("requests for a legacy video: %v", tripper.Paths)
+	}
+
+	manifest, err := readManifest(base + manifestExtension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.ID != ID || len(manifest.Files) != len(files) {
+		t.Errorf("backfilled manifest = %+v", manifest)
+	}
+	if err := checkManifest(dir, manifest); err != nil {
+		t.Error(err)
+	}
+
+	// once its manifest is cleared, it is archived again
+	directTripper = fixtureTripper{}
+	if err := clearManifest(base+manifestExtension, ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := archiveID(context.Background(), ID); err != nil {
+		t.Fatal(err)
+	}
+	if manifests, _ := filepath.Glob(filepath.Join(dir, ID+"_Legacy*"+manifestExtension)); len(manifests) != 1 {
+		t.Errorf("video not archived again, manifests = %q", manifests)
+	}
+	for extension := range files {
+		if _, err := os.Stat(base + extension); err != nil {
+			t.Errorf("%s was removed", extension)
+		}
+	}
+}
+
+// cancelingTripper cancels the archive once a request to Path is sent
+type cancelingTripper struct {
+	Path   string
+	Cancel context.CancelFunc
+}
+
+func (t cancelingTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasPrefix(req.URL.Path, t.Path) {
+		t.Cancel()
+		return nil, req.Context().Err()
+	}
+	return fixtureTripper{}.RoundTrip(req)
+}
+
+func TestArchiveIDCanceled(t *testing.T) {
+	defer useFixtures(t)()
+
+	// the watch page, the subtitles and the thumbnail
+	for _, path := range []string{"/watch", "/api/timedtext", "/vi/"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		directTripper = cancelingTripper{Path: path, Cancel: cancel}
+
+		err := archiveID(ctx, legacyFixtureID)
+		cancel()
+		if err == nil {
+			t.Errorf("%s: archiveID() succeeded once canceled", path)
+		}
+
+		// the only thing that may be left is the empty staging directory
+		filepath.Walk(arguments.Output, func(file string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				t.Errorf("%s: %s written once canceled", path, file)
+			}
+			return nil
+		})
+	}
+}
+
+// errorPageTripper answers StatusCode to the requests to Path
+type errorPageTripper struct {
+	Path       string
+	StatusCode int
+}
+
+func (t errorPageTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasPrefix(req.URL.Path, t.Path) {
+		return statusTripper{StatusCode: t.StatusCode, Header: make(http.Header)}.RoundTrip(req)
+	}
+	return fixtureTripper{}.RoundTrip(req)
+}
+
+func TestArchiveIDErrorPages(t *testing.T) {
+	defer useFixtures(t)()
+
+	tests := []struct {
+		path       string
+		statusCode int
+		class      string
+	}{
+		{"/vi/", http.StatusTooManyRequests, failureTransient},
+		{"/vi/", http.StatusInternalServerError, failureTransient},
+		{"/api/timedtext", http.StatusTooManyRequests, failureTransient},
+		{"/api/timedtext", http.StatusNotFound, failurePermanent},
+	}
+
+	for _, test := range tests {
+		directTripper = errorPageTripper{Path: test.path, StatusCode: test.statusCode}
+		err := archiveID(context.Background(), legacyFixtureID)
+		if status, ok := err.(*StatusError); !ok || status.StatusCode != test.statusCode {
+			t.Errorf("%s answering %d: archiveID() = %v", test.path, test.statusCode, err)
+			continue
+		}
+		if class := classifyError(err); class != test.class {
+			t.Errorf("%s answering %d: class = %s, want %s", test.path, test.statusCode, class, test.class)
+		}
+
+		// the error page isn't archived
+		dir := filepath.Join(arguments.Output, legacyFixtureID[:1], legacyFixtureID[:3], legacyFixtureID)
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("%s answering %d: %s was written", test.path, test.statusCode, dir)
+		}
+	}
+}
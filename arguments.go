@@ -1,25 +1,46 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/akamensky/argparse"
 )
 
 var arguments = struct {
-	Concurrency int
-	Output      string
-	Secret      string
-	Proxy       *url.URL
-	Verbose     bool
+	Concurrency    int
+	Output         string
+	Secret         string
+	Inputs         []string
+	Queue          string
+	Proxies        []*url.URL
+	ProxyStrategy  string
+	CABundle       string
+	Cookies        string
+	Sessions       []string
+	Timeout        time.Duration
+	InnerTube      bool
+	Record         string
+	Replay         string
+	RequiredFields map[string]bool
+	DirTemplate    string
+	FileTemplate   string
+	Refresh        bool
+	Verbose        bool
 }{}
 
 func parseArgs(args []string) {
 	// Create new parser object
-	parser := argparse.NewParser("YouTube-MA", "YouTube metadata archiver")
+	parser := argparse.NewParser("YouTube-MA", "YouTube metadata archiver, "+
+		"takes video IDs, files of IDs or - for stdin as first arguments, "+
+		"or fetch IDs from youtube.the-eye.eu with --secret")
+
+	// Video IDs, files and stdin are given before any flag
+	inputs, args := splitInputs(args)
 
 	concurrency := parser.Int("j", "concurrency", &argparse.Options{
 		Required: false,
@@ -32,10 +53,70 @@ func parseArgs(args []string) {
 		Default:  "videos"})
 
 	secret := parser.String("s", "secret", &argparse.Options{
-		Required: true,
+		Required: false,
 		Help:     "Secret youtube.the-eye.eu API key",
+		Default:  ""})
+
+	queuePath := parser.String("q", "queue", &argparse.Options{
+		Required: false,
+		Help:     "Local queue file, keeping track of every video across runs",
+		Default:  ""})
+
+	timeout := parser.Int("t", "timeout", &argparse.Options{
+		Required: false,
+		Help:     "Maximum time in seconds to archive a single video",
+		Default:  600})
+
+	innertube := parser.Flag("", "innertube", &argparse.Options{
+		Required: false,
+		Help:     "Get metadata from the InnerTube API instead of the watch page",
 		Default:  false})
 
+	record := parser.String("", "record", &argparse.Options{
+		Required: false,
+		Help:     "Record every HTTP exchange to this directory",
+		Default:  ""})
+
+	replay := parser.String("", "replay", &argparse.Options{
+		Required: false,
+		Help:     "Serve the HTTP exchanges recorded in this directory instead of using the network",
+		Default:  ""})
+
+	requiredFields := parser.String("", "required-fields", &argparse.Options{
+		Required: false,
+		Help:     "Comma-separated fields without which a video isn't archived, among " + strings.Join(knownFields, ","),
+		Default:  defaultRequiredFields})
+
+	dirTemplate := parser.String("", "dir-template", &argparse.Options{
+		Required: false,
+		Help:     "Directory of each video in the output directory, with %(field)s placeholders for the info.json fields",
+		Default:  defaultDirTemplate})
+
+	fileTemplate := parser.String("", "file-template", &argparse.Options{
+		Required: false,
+		Help:     "Base name of the files of each video, with %(field)s placeholders for the info.json fields",
+		Default:  defaultFileTemplate})
+
+	refresh := parser.Flag("", "refresh", &argparse.Options{
+		Required: false,
+		Help:     "Fetch the archived videos again, keeping what changed in timestamped .changes.json files",
+		Default:  false})
+
+	requestRate := parser.Float("", "rate", &argparse.Options{
+		Required: false,
+		Help:     "Maximum requests per second to each host, 0 for no limit",
+		Default:  0.0})
+
+	globalRate := parser.Float("", "global-rate", &argparse.Options{
+		Required: false,
+		Help:     "Maximum requests per second whatever the host is, checked before --rate, 0 for no limit",
+		Default:  0.0})
+
+	hostRates := parser.String("", "host-rate", &argparse.Options{
+		Required: false,
+		Help:     "Comma-separated host=rate list overriding --rate for these hosts, such as i3.ytimg.com=20",
+		Default:  ""})
+
 	verbose := parser.Flag("v", "verbose", &argparse.Options{
 		Required: false,
 		Help:     "Verbose output",
@@ -43,9 +124,59 @@ func parseArgs(args []string) {
 
 	proxy := parser.String("p", "proxy", &argparse.Options{
 		Required: false,
-		Help:     "Proxy url",
+		Help:     "Proxy url (http, https, socks5 or socks5h, with optional user:password), or comma-separated list of proxy urls",
+		Default:  ""})
+
+	proxyFile := parser.String("", "proxy-file", &argparse.Options{
+		Required: false,
+		Help:     "File listing proxy urls, one per line",
+		Default:  ""})
+
+	proxyStrategy := parser.Selector("", "proxy-strategy", []string{proxyRoundRobin, proxyLeastFailed}, &argparse.Options{
+		Required: false,
+		Help:     "How the proxy of each request is picked",
+		Default:  proxyRoundRobin})
+
+	caBundle := parser.String("", "ca-bundle", &argparse.Options{
+		Required: false,
+		Help:     "PEM file of certificate authorities to trust on top of the system ones",
+		Default:  ""})
+
+	cookies := parser.String("", "cookies", &argparse.Options{
+		Required: false,
+		Help:     "Netscape cookies.txt file loaded before the run and updated with the new cookies after it",
 		Default:  ""})
 
+	session := parser.String("", "session", &argparse.Options{
+		Required: false,
+		Help:     "Cookies.txt file exported from a browser signed in to YouTube, or comma-separated list of them, to archive age-restricted and members-only videos; each worker uses one of them",
+		Default:  ""})
+
+	maxConnsPerHost := parser.Int("", "max-conns-per-host", &argparse.Options{
+		Required: false,
+		Help:     "Maximum connections to each host, reused across videos, 0 for no limit",
+		Default:  transportOptions.MaxConnsPerHost})
+
+	dialTimeout := parser.Int("", "dial-timeout", &argparse.Options{
+		Required: false,
+		Help:     "Maximum time in seconds to connect to a host",
+		Default:  int(transportOptions.DialTimeout / time.Second)})
+
+	tlsTimeout := parser.Int("", "tls-timeout", &argparse.Options{
+		Required: false,
+		Help:     "Maximum time in seconds for the TLS handshake",
+		Default:  int(transportOptions.TLSHandshakeTimeout / time.Second)})
+
+	headerTimeout := parser.Int("", "header-timeout", &argparse.Options{
+		Required: false,
+		Help:     "Maximum time in seconds to wait for the response headers once the request is sent",
+		Default:  int(transportOptions.ResponseHeaderTimeout / time.Second)})
+
+	dnsCacheTTL := parser.Int("", "dns-cache", &argparse.Options{
+		Required: false,
+		Help:     "Cache the resolved host names for this many seconds, 0 to resolve them every time",
+		Default:  0})
+
 	// Parse input
 	err := parser.Parse(args)
 	if err != nil {
@@ -55,16 +186,109 @@ func parseArgs(args []string) {
 		os.Exit(0)
 	}
 
-	if *proxy != "" {
-		arguments.Proxy, _ = url.Parse(*proxy)
+	// We need something to archive
+	if len(inputs) == 0 && *secret == "" && *queuePath == "" {
+		fmt.Print(parser.Usage(errors.New("no video IDs, --queue or --secret provided")))
+		os.Exit(0)
+	}
+
+	for _, raw := range strings.Split(*proxy, ",") {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		u, err := parseProxy(strings.TrimSpace(raw))
+		if err != nil {
+			fmt.Print(parser.Usage(err))
+			os.Exit(0)
+		}
+		arguments.Proxies = append(arguments.Proxies, u)
+	}
+
+	if *proxyFile != "" {
+		f, err := os.Open(*proxyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while opening the proxy file: %s\n", err.Error())
+			os.Exit(1)
+		}
+		proxies, err := readProxies(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while reading the proxy file: %s\n", err.Error())
+			os.Exit(1)
+		}
+		arguments.Proxies = append(arguments.Proxies, proxies...)
+	}
+	arguments.ProxyStrategy = *proxyStrategy
+	arguments.CABundle = *caBundle
+	arguments.Cookies = *cookies
+	for _, path := range strings.Split(*session, ",") {
+		if strings.TrimSpace(path) != "" {
+			arguments.Sessions = append(arguments.Sessions, strings.TrimSpace(path))
+		}
+	}
+
+	if *record != "" && *replay != "" {
+		fmt.Print(parser.Usage(errors.New("--record and --replay can't be used together")))
+		os.Exit(0)
+	}
+
+	if *record != "" {
+		err = os.MkdirAll(*record, 0755)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while creating the cassette directory: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	arguments.RequiredFields, err = parseRequiredFields(*requiredFields)
+	if err != nil {
+		fmt.Print(parser.Usage(err))
+		os.Exit(0)
+	}
+
+	perHostRates, err := parseHostRates(*hostRates)
+	if err != nil {
+		fmt.Print(parser.Usage(err))
+		os.Exit(0)
+	}
+	rateLimits = newRateLimits(*globalRate, *requestRate, perHostRates)
+
+	if *maxConnsPerHost < 0 || *dialTimeout <= 0 || *tlsTimeout <= 0 || *headerTimeout <= 0 || *dnsCacheTTL < 0 {
+		fmt.Print(parser.Usage(errors.New("connection limits and timeouts can't be negative, and timeouts can't be 0")))
+		os.Exit(0)
+	}
+	transportOptions.MaxConnsPerHost = *maxConnsPerHost
+	transportOptions.MaxIdleConnsPerHost = *maxConnsPerHost
+	transportOptions.DialTimeout = time.Duration(*dialTimeout) * time.Second
+	transportOptions.TLSHandshakeTimeout = time.Duration(*tlsTimeout) * time.Second
+	transportOptions.ResponseHeaderTimeout = time.Duration(*headerTimeout) * time.Second
+	transportOptions.DNSCacheTTL = time.Duration(*dnsCacheTTL) * time.Second
+	if transportOptions.DNSCacheTTL > 0 {
+		resolver = newDNSCache(transportOptions.DNSCacheTTL)
+	}
+
+	if *concurrency <= 0 || *timeout <= 0 {
+		fmt.Print(parser.Usage(errors.New("the concurrency and the timeout must be greater than 0")))
+		os.Exit(0)
 	}
 
 	// Remove trailing slash in output path
-	*output = strings.Replace(*output, "/", "", -1)
+	if len(*output) > 1 {
+		*output = strings.TrimRight(*output, "/")
+	}
 
 	// Fill arguments structure
 	arguments.Concurrency = *concurrency
 	arguments.Output = *output
 	arguments.Secret = *secret
+	arguments.Inputs = inputs
+	arguments.Queue = *queuePath
 	arguments.Verbose = *verbose
+	arguments.Timeout = time.Duration(*timeout) * time.Second
+	arguments.InnerTube = *innertube
+	arguments.Record = *record
+	arguments.Replay = *replay
+	arguments.DirTemplate = strings.Trim(*dirTemplate, "/")
+	arguments.FileTemplate = *fileTemplate
+	arguments.Refresh = *refresh
 }
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// States of a video in the local queue
+const (
+	statePending    = "pending"
+	stateInProgress = "in-progress"
+	stateDone       = "done"
+	stateFailed     = "failed"
+	// the video failed and will be tried again once NotBefore is reached
+	stateRetrying = "retrying"
+	// the video will never be archived, its last error is the reason
+	stateUnavailable = "unavailable"
+)
+
+var (
+	videosBucket     = []byte("videos")
+	pendingBucket    = []byte("pending")
+	inProgressBucket = []byte("in-progress")
+	retryingBucket   = []byte("retrying")
+)
+
+// QueueEntry is what the local queue knows about a video
+type QueueEntry struct {
+	State     string    `json:"state"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	NotBefore time.Time `json:"not_before,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+	FailureHistory
+}
+
+// boltQueue is a WorkQueue persisted in a BoltDB file, every state
+// change is committed before being acknowledged so that a crash
+// never loses track of a video
+type boltQueue struct {
+	db *bolt.DB
+}
+
+func openBoltQueue(path string) (*boltQueue, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	q := &boltQueue{db: db}
+	err = q.recover()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return q, nil
+}
+
+// recover put back in the pending list the videos that were
+// in progress when the previous run stopped
+func (q *boltQueue) recover() error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{videosBucket, pendingBucket, inProgressBucket, retryingBucket} {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+
+		var IDs []string
+		err := tx.Bucket(inProgressBucket).ForEach(func(k, v []byte) error {
+			IDs = append(IDs, string(k))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, ID := range IDs {
+			entry, err := getEntry(tx, ID)
+			if err != nil {
+				return err
+			}
+			entry.State = statePending
+			err = setEntry(tx, ID, entry)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func getEntry(tx *bolt.Tx, ID string) (*QueueEntry, error) {
+	data := tx.Bucket(videosBucket).Get([]byte(ID))
+	if data == nil {
+		return nil, nil
+	}
+
+	entry := new(QueueEntry)
+	err := json.Unmarshal(data, entry)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// setEntry store the entry and keep the pending and
+// in-progress indexes in sync with its state
+func setEntry(tx *bolt.Tx, ID string, entry *QueueEntry) error {
+	entry.UpdatedAt = time.Now()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Bucket(videosBucket).Put([]byte(ID), data)
+	if err != nil {
+		return err
+	}
+
+	inProgress := tx.Bucket(inProgressBucket)
+	if entry.State == stateInProgress {
+		err = inProgress.Put([]byte(ID), nil)
+	} else {
+		err = inProgress.Delete([]byte(ID))
+	}
+	if err != nil {
+		return err
+	}
+
+	if entry.State == stateRetrying {
+		return tx.Bucket(retryingBucket).Put(retryKey(entry.NotBefore, ID), []byte(ID))
+	}
+
+	if entry.State == statePending {
+		pending := tx.Bucket(pendingBucket)
+		seq, err := pending.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return pending.Put(key, []byte(ID))
+	}
+	return nil
+}
+
+// retryKey sorts the retries by time
+func retryKey(notBefore time.Time, ID string) []byte {
+	key := make([]byte, 8, 8+len(ID))
+	binary.BigEndian.PutUint64(key, uint64(notBefore.UnixNano()))
+	return append(key, ID...)
+}
+
+// dueRetries puts back in the pending list the videos whose retry is due
+func dueRetries(tx *bolt.Tx, now time.Time) error {
+	var keys [][]byte
+
+	c := tx.Bucket(retryingBucket).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if int64(binary.BigEndian.Uint64(k[:8])) > now.UnixNano() {
+			break
+		}
+		keys = append(keys, k)
+
+		ID := string(v)
+		entry, err := getEntry(tx, ID)
+		if err != nil {
+			return err
+		}
+
+		// Stale index entry, the video moved on since
+		if entry == nil || entry.State != stateRetrying {
+			continue
+		}
+
+		entry.State = statePending
+		err = setEntry(tx, ID, entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, k := range keys {
+		err := tx.Bucket(retryingBucket).Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fetch returns the oldest pending IDs and mark them as in progress, it
+// returns no ID while videos are in progress or waiting for a retry
+func (q *boltQueue) Fetch(ctx context.Context, limit int) (IDs []string, err error) {
+	waiting := false
+	err = q.db.Update(func(tx *bolt.Tx) error {
+		err := dueRetries(tx, time.Now())
+		if err != nil {
+			return err
+		}
+
+		var keys [][]byte
+
+		c := tx.Bucket(pendingBucket).Cursor()
+		for k, v := c.First(); k != nil && len(IDs) < limit; k, v = c.Next() {
+			keys = append(keys, k)
+
+			ID := string(v)
+			entry, err := getEntry(tx, ID)
+			if err != nil {
+				return err
+			}
+
+			// Stale index entry, the video moved on since
+			if entry == nil || entry.State != statePending {
+				continue
+			}
+
+			entry.State = stateInProgress
+			entry.Attempts++
+			err = setEntry(tx, ID, entry)
+			if err != nil {
+				return err
+			}
+			IDs = append(IDs, ID)
+		}
+
+		for _, k := range keys {
+			err := tx.Bucket(pendingBucket).Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		inProgress, _ := tx.Bucket(inProgressBucket).Cursor().First()
+		retrying, _ := tx.Bucket(retryingBucket).Cursor().First()
+		waiting = inProgress != nil || retrying != nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(IDs) == 0 && !waiting {
+		return nil, io.EOF
+	}
+	return IDs, nil
+}
+
+// Ack marks IDs as done
+func (q *boltQueue) Ack(ctx context.Context, IDs ...string) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		for _, ID := range IDs {
+			entry, err := getEntry(tx, ID)
+			if err != nil {
+				return err
+			}
+			if entry == nil {
+				entry = new(QueueEntry)
+			}
+
+			entry.State = stateDone
+			entry.LastError = ""
+			entry.reset()
+			err = setEntry(tx, ID, entry)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Nack schedules a retry of the ID according to the class of the
+// error, or marks it as failed once it ran out of attempts
+func (q *boltQueue) Nack(ctx context.Context, ID string, reason error) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		entry, err := getEntry(tx, ID)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			entry = new(QueueEntry)
+		}
+
+		entry.State = stateFailed
+		if reason != nil {
+			entry.LastError = reason.Error()
+			if retryAt, ok := entry.record(reason, time.Now()); ok {
+				entry.State = stateRetrying
+				entry.NotBefore = retryAt
+			}
+		}
+		return setEntry(tx, ID, entry)
+	})
+}
+
+// Tombstone marks the ID as unavailable, with the reason as its last error
+func (q *boltQueue) Tombstone(ctx context.Context, ID string, reason string) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		entry, err := getEntry(tx, ID)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			entry = new(QueueEntry)
+		}
+
+		entry.State = stateUnavailable
+		entry.LastError = reason
+		return setEntry(tx, ID, entry)
+	})
+}
+
+// Push adds IDs as pending, IDs already known are left untouched
+func (q *boltQueue) Push(ctx context.Context, IDs ...string) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		for _, ID := range IDs {
+			entry, err := getEntry(tx, ID)
+			if err != nil {
+				return err
+			}
+			if entry != nil {
+				continue
+			}
+
+			err = setEntry(tx, ID, &QueueEntry{State: statePending})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (q *boltQueue) Requeue(ctx context.Context, IDs ...string) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		for _, ID := range IDs {
+			entry, err := getEntry(tx, ID)
+			if err != nil {
+				return err
+			}
+			if entry == nil {
+				entry = new(QueueEntry)
+			}
+			if entry.State == statePending || entry.State == stateInProgress {
+				continue
+			}
+
+			entry.State = statePending
+			entry.LastError = ""
+			entry.reset()
+			err = setEntry(tx, ID, entry)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (q *boltQueue) Close() error {
+	return q.db.Close()
+}
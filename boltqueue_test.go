@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// tempBoltQueue returns the path of a queue in a temporary
+// directory, removed by the returned function
+func tempBoltQueue(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "youtube-ma-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "queue.db"), func() { os.RemoveAll(dir) }
+}
+
+func queueState(t *testing.T, q *boltQueue, ID string) string {
+	var entry *QueueEntry
+	err := q.db.View(func(tx *bolt.Tx) error {
+		var err error
+		entry, err = getEntry(tx, ID)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil {
+		return ""
+	}
+	return entry.State
+}
+
+func TestBoltQueue(t *testing.T) {
+	path, cleanup := tempBoltQueue(t)
+	defer cleanup()
+
+	q, err := openBoltQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	ctx := context.Background()
+	if _, err := q.Fetch(ctx, 10); err != io.EOF {
+		t.Fatalf("Fetch() on an empty queue returned %v, want io.EOF", err)
+	}
+
+	q.Push(ctx, "a", "b", "c")
+	// pushing a known ID again doesn't queue it twice
+	q.Push(ctx, "a")
+
+	IDs, err := q.Fetch(ctx, 2)
+	if err != nil || !reflect.DeepEqual(IDs, []string{"a", "b"}) {
+		t.Fatalf("Fetch() = %q, %v", IDs, err)
+	}
+	if state := queueState(t, q, "a"); state != stateInProgress {
+		t.Errorf("state of a = %q", state)
+	}
+
+	IDs, err = q.Fetch(ctx, 10)
+	if err != nil || !reflect.DeepEqual(IDs, []string{"c"}) {
+		t.Fatalf("Fetch() = %q, %v", IDs, err)
+	}
+
+	// nothing pending, but videos are still in progress
+	if IDs, err := q.Fetch(ctx, 10); err != nil || len(IDs) != 0 {
+		t.Fatalf("Fetch() = %q, %v", IDs, err)
+	}
+
+	q.Ack(ctx, "a", "b")
+	q.Nack(ctx, "c", nil)
+	for ID, want := range map[string]string{"a": stateDone, "b": stateDone, "c": stateFailed} {
+		if state := queueState(t, q, ID); state != want {
+			t.Errorf("state of %s = %q, want %q", ID, state, want)
+		}
+	}
+	if _, err := q.Fetch(ctx, 10); err != io.EOF {
+		t.Errorf("Fetch() returned %v, want io.EOF", err)
+	}
+}
+
+func TestBoltQueueRecover(t *testing.T) {
+	path, cleanup := tempBoltQueue(t)
+	defer cleanup()
+
+	q, err := openBoltQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	q.Push(ctx, "a", "b")
+	if IDs, err := q.Fetch(ctx, 10); err != nil || len(IDs) != 2 {
+		t.Fatalf("Fetch() = %q, %v", IDs, err)
+	}
+	q.Ack(ctx, "a")
+
+	// the run stops with b still in progress
+	q.Close()
+
+	q, err = openBoltQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if state := queueState(t, q, "b"); state != statePending {
+		t.Errorf("state of b after reopening = %q", state)
+	}
+	IDs, err := q.Fetch(ctx, 10)
+	if err != nil || !reflect.DeepEqual(IDs, []string{"b"}) {
+		t.Fatalf("Fetch() after reopening = %q, %v", IDs, err)
+	}
+	if state := queueState(t, q, "a"); state != stateDone {
+		t.Errorf("state of a after reopening = %q", state)
+	}
+}
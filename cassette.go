@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// CassetteEntry is a recorded request/response pair
+type CassetteEntry struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	RequestBody []byte      `json:"request_body,omitempty"`
+	StatusCode  int         `json:"status_code"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+	RecordedAt  time.Time   `json:"recorded_at"`
+}
+
+// cassetteKey identifies a request by its method, URL and body
+func cassetteKey(method string, URL string, body []byte) string {
+	hash := sha1.New()
+	hash.Write([]byte(method + " " + URL + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// readRequestBody reads the body of the request and puts it back
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// recordTripper writes every exchange to a cassette directory
+type recordTripper struct {
+	Dir     string
+	Tripper http.RoundTripper
+}
+
+func (t *recordTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := t.Tripper.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	entry := CassetteEntry{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		RequestBody: requestBody,
+		StatusCode:  res.StatusCode,
+		Header:      res.Header,
+		Body:        body,
+		RecordedAt:  time.Now(),
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write to a temporary file first so that a replay never
+	// reads a half-written entry
+	path := filepath.Join(t.Dir, cassetteKey(req.Method, entry.URL, requestBody)+".json")
+	err = writeFileAtomic(path, data, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// replayTripper serves the exchanges of a cassette
+// directory and never touches the network
+type replayTripper struct {
+	Dir string
+}
+
+func (t *replayTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(t.Dir, cassetteKey(req.Method, req.URL.String(), requestBody)+".json"))
+	if os.IsNotExist(err) {
+		return nil, errors.New("no recorded response for " + req.Method + " " + req.URL.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry := new(CassetteEntry)
+	err = json.Unmarshal(data, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(entry.StatusCode) + " " + http.StatusText(entry.StatusCode),
+		StatusCode:    entry.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        entry.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(entry.Body)),
+		ContentLength: int64(len(entry.Body)),
+		Request:       req,
+	}, nil
+}
+
+// cassetteTripper wraps the tripper according to --record and --replay
+func cassetteTripper(tripper http.RoundTripper) http.RoundTripper {
+	if arguments.Replay != "" {
+		return &replayTripper{Dir: arguments.Replay}
+	}
+	if arguments.Record != "" {
+		return &recordTripper{Dir: arguments.Record, Tripper: tripper}
+	}
+	return tripper
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// offlineTripper fails every request
+type offlineTripper struct{}
+
+func (offlineTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, errors.New("network access during replay: " + req.URL.String())
+}
+
+// archiveInfoJSON archives the video and returns its info.json
+func archiveInfoJSON(t *testing.T, ID string) []byte {
+	if err := archiveID(context.Background(), ID); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, _ := filepath.Glob(filepath.Join(arguments.Output, ID[:1], ID[:3], ID, "*.info.json"))
+	if len(infos) != 1 {
+		t.Fatalf("info.json files = %q", infos)
+	}
+	data, err := ioutil.ReadFile(infos[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRecordReplay(t *testing.T) {
+	defer useFixtures(t)()
+
+	cassette, err := ioutil.TempDir("", "youtube-ma-cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cassette)
+
+	arguments.Record = cassette
+	recorded := archiveInfoJSON(t, legacyFixtureID)
+
+	entries, _ := filepath.Glob(filepath.Join(cassette, "*.json"))
+	// watch page, subtitles list, 2 subtitles and the thumbnail
+	if len(entries) != 5 {
+		t.Errorf("recorded %d exchanges, want 5", len(entries))
+	}
+
+	os.RemoveAll(arguments.Output)
+	directTripper = offlineTripper{}
+	arguments.Record = ""
+	arguments.Replay = cassette
+	replayed := archiveInfoJSON(t, legacyFixtureID)
+
+	if !bytes.Equal(recorded, replayed) {
+		t.Errorf("replayed info.json differs:\n%s\nrecorded:\n%s", replayed, recorded)
+	}
+}
+
+func TestReplayMissing(t *testing.T) {
+	tripper := &replayTripper{Dir: "testdata"}
+	req, _ := http.NewRequest(http.MethodGet, "https://www.youtube.com/watch?v=notRecorded", nil)
+	if _, err := tripper.RoundTrip(req); err == nil {
+		t.Error("expected an error for a request missing from the cassette")
+	}
+}
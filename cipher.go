@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// Patterns finding the URL of the player of the video, in the
+// ytcfg of modern pages and in the assets of the legacy ones
+var playerURLPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`"jsUrl"\s*:\s*"([^"]+base\.js)"`),
+	regexp.MustCompile(`"PLAYER_JS_URL"\s*:\s*"([^"]+base\.js)"`),
+	regexp.MustCompile(`"js"\s*:\s*"([^"]+base\.js)"`),
+}
+
+// playerIDPattern finds the current player in the iframe API, used
+// when there is no watch page to take it from
+var playerIDPattern = regexp.MustCompile(`player\\?/([0-9a-zA-Z_-]+)\\?/`)
+
+// iframeAPIURL is requested to find the player, tests replace it
+var iframeAPIURL = "https://www.youtube.com/iframe_api"
+
+// Patterns finding the name of the function deciphering the
+// signatures, checked in order, the first group is the name
+var signatureFunctionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\b[cs]\s*&&\s*[adf]\.set\([^,]+\s*,\s*encodeURIComponent\s*\(\s*([a-zA-Z0-9$]+)\(`),
+	regexp.MustCompile(`\b[a-zA-Z0-9]+\s*&&\s*[a-zA-Z0-9]+\.set\([^,]+\s*,\s*encodeURIComponent\s*\(\s*([a-zA-Z0-9$]+)\(`),
+	regexp.MustCompile(`\bm=([a-zA-Z0-9$]{2,})\(decodeURIComponent\(h\.s\)\)`),
+	regexp.MustCompile(`([a-zA-Z0-9$]+)\s*=\s*function\(\s*a\s*\)\s*\{\s*a\s*=\s*a\.split\(\s*""\s*\)`),
+}
+
+// nFunctionPattern finds the function transforming the n parameter,
+// the second group is its index when it's stored in an array
+var nFunctionPattern = regexp.MustCompile(`\.get\("n"\)\)\s*&&\s*\(\s*b\s*=\s*([a-zA-Z0-9$]+)(?:\[(\d+)\])?\(\s*[a-zA-Z0-9]\s*\)`)
+
+// helperObjectPattern finds the object whose methods the signature function calls
+var helperObjectPattern = regexp.MustCompile(`;\s*([a-zA-Z0-9$]+)\.[a-zA-Z0-9$]+\(\s*a\s*,`)
+
+// nGuardPattern matches the early return of the n function checking
+// for a global of the player that doesn't exist once extracted
+var nGuardPattern = regexp.MustCompile(`;\s*if\s*\(\s*typeof\s+[a-zA-Z0-9_$]+\s*===?\s*(?:"undefined"|'undefined'|[a-zA-Z0-9_$]+\[\d+\])\s*\)\s*return\s+[a-zA-Z0-9_$]+\s*;`)
+
+// nFailurePrefix is returned by the n function instead of throwing
+const nFailurePrefix = "enhanced_except_"
+
+var errNoNFunction = errors.New("no n function found in the player, cancelation")
+
+// playerCallTimeout bounds each call of a function of the player,
+// a player changing in a way we don't expect could loop forever
+var playerCallTimeout = 5 * time.Second
+
+// Player structure containing the functions of a base.js, run in
+// an embedded interpreter that only one goroutine can use at a time
+type Player struct {
+	URL        string
+	lock       sync.Mutex
+	vm         *goja.Runtime
+	signature  goja.Callable
+	nTransform goja.Callable
+}
+
+// players are the players already loaded, by URL, so that
+// base.js is fetched once for every video using it
+var (
+	playersLock sync.Mutex
+	players     = make(map[string]*Player)
+	// why the players that can't be used failed, and when
+	playerFailures = make(map[string]playerFailure)
+)
+
+// playerFailure structure containing why a player couldn't be loaded
+type playerFailure struct {
+	err error
+	at  time.Time
+}
+
+// playerFailureTTL is how long a player that can't be used is given up
+// on, rather than fetching its base.js again for every video
+var playerFailureTTL = 10 * time.Minute
+
+// findPlayerURL returns the absolute URL of the player of the page
+func findPlayerURL(page string) string {
+	for _, pattern := range playerURLPatterns {
+		if m := pattern.FindStringSubmatch(page); m != nil {
+			return absolutePlayerURL(strings.Replace(m[1], `\/`, "/", -1))
+		}
+	}
+	return ""
+}
+
+func absolutePlayerURL(path string) string {
+	switch {
+	case strings.HasPrefix(path, "//"):
+		return "https:" + path
+	case strings.HasPrefix(path, "/"):
+		return "https://www.youtube.com" + path
+	}
+	return path
+}
+
+// jsFunctionEnd returns the index following the brace closing the one at
+// start, skipping the braces in string literals, -1 if it isn't closed
+func jsFunctionEnd(js string, start int) int {
+	depth := 0
+	for i := start; i < len(js); i++ {
+		switch js[i] {
+		case '"', '\'', '`':
+			quote := js[i]
+			for i++; i < len(js) && js[i] != quote; i++ {
+				if js[i] == '\\' {
+					i++
+				}
+			}
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// extractBlock returns the code from the match of pattern to the
+// brace closing the first brace of the match
+func extractBlock(js string, pattern *regexp.Regexp) (string, bool) {
+	loc := pattern.FindStringIndex(js)
+	if loc == nil {
+		return "", false
+	}
+	open := strings.Index(js[loc[0]:], "{")
+	if open == -1 {
+		return "", false
+	}
+	end := jsFunctionEnd(js, loc[0]+open)
+	if end == -1 {
+		return "", false
+	}
+	return js[loc[0]:end], true
+}
+
+// extractFunction returns the definition of the function name
+func extractFunction(js string, name string) (string, bool) {
+	quoted := regexp.QuoteMeta(name)
+	for _, pattern := range []string{
+		`(?:^|[;,\s])` + quoted + `\s*=\s*function\s*\(`,
+		`function\s+` + quoted + `\s*\(`,
+	} {
+		code, ok := extractBlock(js, regexp.MustCompile(pattern))
+		if ok {
+			// the match may start with the separator
+			return code[strings.Index(code, name):], true
+		}
+	}
+	return "", false
+}
+
+// extractSignatureCode returns standalone code defining the signature
+// function and its helper object, and the name of the function
+func extractSignatureCode(js string) (string, string, error) {
+	var name string
+	for _, pattern := range signatureFunctionPatterns {
+		if m := pattern.FindStringSubmatch(js); m != nil {
+			name = m[1]
+			break
+		}
+	}
+	if name == "" {
+		return "", "", errors.New("no signature function found in the player, cancelation")
+	}
+
+	function, ok := extractFunction(js, name)
+	if !ok {
+		return "", "", errors.New("signature function " + name + " not found in the player, cancelation")
+	}
+
+	m := helperObjectPattern.FindStringSubmatch(function)
+	if m == nil {
+		return "var " + function + ";", name, nil
+	}
+	helper, ok := extractBlock(js, regexp.MustCompile(`var\s+`+regexp.QuoteMeta(m[1])+`\s*=\s*\{`))
+	if !ok {
+		return "", "", errors.New("helper object " + m[1] + " of the signature function not found in the player, cancelation")
+	}
+	return helper + ";var " + function + ";", name, nil
+}
+
+// extractNCode returns standalone code defining the
+// function transforming the n parameter, and its name
+func extractNCode(js string) (string, string, error) {
+	m := nFunctionPattern.FindStringSubmatch(js)
+	if m == nil {
+		return "", "", errNoNFunction
+	}
+
+	name := m[1]
+	if m[2] != "" {
+		array := regexp.MustCompile(`var\s+` + regexp.QuoteMeta(name) + `\s*=\s*\[([^\]]*)\]`).FindStringSubmatch(js)
+		if array == nil {
+			return "", "", errNoNFunction
+		}
+		elements := strings.Split(array[1], ",")
+		index, _ := strconv.Atoi(m[2])
+		if index >= len(elements) {
+			return "", "", errNoNFunction
+		}
+		name = strings.TrimSpace(elements[index])
+	}
+
+	function, ok := extractFunction(js, name)
+	if !ok {
+		return "", "", errNoNFunction
+	}
+	return "var " + nGuardPattern.ReplaceAllString(function, ";") + ";", name, nil
+}
+
+// newPlayer extracts the functions of base.js, the n function is
+// optional as the players before 2021 don't throttle
+func newPlayer(playerURL string, js string) (*Player, error) {
+	player := &Player{URL: playerURL, vm: goja.New()}
+
+	code, name, err := extractSignatureCode(js)
+	if err != nil {
+		return nil, err
+	}
+	player.signature, err = player.compile(code, name)
+	if err != nil {
+		return nil, err
+	}
+
+	code, name, err = extractNCode(js)
+	if err == errNoNFunction {
+		return player, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	player.nTransform, err = player.compile(code, name)
+	if err != nil {
+		return nil, err
+	}
+	return player, nil
+}
+
+// compile runs the code and returns the function it defines
+func (p *Player) compile(code string, name string) (goja.Callable, error) {
+	_, err := p.vm.RunString(code)
+	if err != nil {
+		return nil, errors.New("error when running " + name + " of the player: " + err.Error() + ", cancelation")
+	}
+	function, ok := goja.AssertFunction(p.vm.Get(name))
+	if !ok {
+		return nil, errors.New(name + " of the player isn't a function, cancelation")
+	}
+	return function, nil
+}
+
+// call runs the function of the player on the value, interrupting
+// it when ctx is done or when it runs for too long
+func (p *Player) call(ctx context.Context, function goja.Callable, value string) (string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, playerCallTimeout)
+	defer cancel()
+
+	returned := make(chan struct{})
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		select {
+		case <-ctx.Done():
+			p.vm.Interrupt(ctx.Err())
+		case <-returned:
+		}
+	}()
+
+	result, err := function(goja.Undefined(), p.vm.ToValue(value))
+	close(returned)
+	<-watched
+	// the interruption may come after the function returned
+	p.vm.ClearInterrupt()
+
+	if _, ok := err.(*goja.InterruptedError); ok {
+		return "", ctx.Err()
+	}
+	if err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+// decipher returns the signature expected by the video servers
+func (p *Player) decipher(ctx context.Context, s string) (string, error) {
+	return p.call(ctx, p.signature, s)
+}
+
+// transformN returns the n parameter that isn't throttled
+func (p *Player) transformN(ctx context.Context, n string) (string, error) {
+	if p.nTransform == nil {
+		return "", errNoNFunction
+	}
+	result, err := p.call(ctx, p.nTransform, n)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(result, nFailurePrefix) || result == n {
+		return "", errors.New("error when transforming the n parameter " + n + ", cancelation")
+	}
+	return result, nil
+}
+
+// decipherURL returns the usable URL of a format, signing it with
+// the deciphered signature and replacing its throttled n parameter
+func (p *Player) decipherURL(ctx context.Context, rawURL string, s string, sp string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+
+	if s != "" {
+		if sp == "" {
+			sp = "signature"
+		}
+		signature, err := p.decipher(ctx, s)
+		if err != nil {
+			return "", err
+		}
+		query.Set(sp, signature)
+	}
+
+	if n := query.Get("n"); n != "" {
+		n, err = p.transformN(ctx, n)
+		if err != nil {
+			return "", err
+		}
+		query.Set("n", n)
+	}
+
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// loadPlayer returns the player at the URL, fetching base.js the
+// first time, or the current player when the URL isn't known
+func loadPlayer(ctx context.Context, playerURL string) (*Player, error) {
+	if playerURL == "" {
+		res, err := httpGet(ctx, iframeAPIURL)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != 200 {
+			return nil, &StatusError{URL: "the iframe API", StatusCode: res.StatusCode}
+		}
+		m := playerIDPattern.FindSubmatch(body)
+		if m == nil {
+			return nil, errors.New("no player found in the iframe API, cancelation")
+		}
+		playerURL = absolutePlayerURL("/s/player/" + string(m[1]) + "/player_ias.vflset/en_US/base.js")
+	}
+
+	playersLock.Lock()
+	player, ok := players[playerURL]
+	failure, failed := playerFailures[playerURL]
+	playersLock.Unlock()
+	if ok {
+		return player, nil
+	}
+	if failed && time.Since(failure.at) < playerFailureTTL {
+		return nil, failure.err
+	}
+
+	player, err := fetchPlayer(ctx, playerURL)
+
+	playersLock.Lock()
+	defer playersLock.Unlock()
+	if err != nil {
+		// Network errors and throttling don't say anything about the player
+		if classifyError(err) != failureTransient {
+			playerFailures[playerURL] = playerFailure{err: err, at: time.Now()}
+		}
+		return nil, err
+	}
+	players[playerURL] = player
+	delete(playerFailures, playerURL)
+	return player, nil
+}
+
+// fetchPlayer downloads base.js and extracts its functions
+func fetchPlayer(ctx context.Context, playerURL string) (*Player, error) {
+	res, err := httpGet(ctx, playerURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, &StatusError{URL: "the player", StatusCode: res.StatusCode}
+	}
+	js, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPlayer(playerURL, string(js))
+}
+
+// formatNeedsPlayer tells if the URL of the format can't be used as is
+func formatNeedsPlayer(format *Format) bool {
+	if format.signature != "" {
+		return true
+	}
+	u, err := url.Parse(format.URL)
+	return err == nil && u.Query().Get("n") != ""
+}
+
+// decipherFormats replaces the URLs of the formats by usable ones,
+// loading the player only if a format needs it; a failure only
+// stops the archiving when the formats are required
+func decipherFormats(ctx context.Context, video *Video) error {
+	var player *Player
+	for i := range video.InfoJSON.Formats {
+		format := &video.InfoJSON.Formats[i]
+		if !formatNeedsPlayer(format) {
+			continue
+		}
+
+		var err error
+		if player == nil {
+			player, err = loadPlayer(ctx, findPlayerURL(video.RawHTML))
+		}
+		if err == nil {
+			format.URL, err = player.decipherURL(ctx, format.URL, format.signature, format.signatureParam)
+		}
+		if err != nil {
+			if video.FieldStatus == nil {
+				video.FieldStatus = make(map[string]string)
+			}
+			video.FieldStatus[fieldFormats] = err.Error()
+			if arguments.RequiredFields[fieldFormats] {
+				return err
+			}
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const fixturePlayerURL = "https://www.youtube.com/s/player/4fbb4d5b/player_ias.vflset/en_US/base.js"
+
+func loadFixturePlayer(t *testing.T) (*Player, string) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "player", "4fbb4d5b", "base.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	player, err := newPlayer(fixturePlayerURL, string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return player, string(data)
+}
+
+func TestFindPlayerURL(t *testing.T) {
+	video, _ := loadFixture(t, modernFixtureID)
+	if got := findPlayerURL(video.RawHTML); got != fixturePlayerURL {
+		t.Errorf("findPlayerURL(modern page) = %s", got)
+	}
+
+	legacy := `ytplayer.config = {"assets":{"js":"\/yts\/jsbin\/player_ias-vflF2_1R\/en_US\/base.js"}};`
+	if got := findPlayerURL(legacy); got != "https://www.youtube.com/yts/jsbin/player_ias-vflF2_1R/en_US/base.js" {
+		t.Errorf("findPlayerURL(legacy page) = %s", got)
+	}
+	if got := findPlayerURL("<html></html>"); got != "" {
+		t.Errorf("findPlayerURL(no player) = %s", got)
+	}
+}
+
+func TestDecipher(t *testing.T) {
+	player, js := loadFixturePlayer(t)
+
+	// the operations of lma in the fixture, done by hand
+	s := "AOq0QJ8wRQIhAMb8cEvZzdKkYfJ9yDlQ1vXf0rMn3"
+	want := []byte(s)
+	reverse := func() {
+		for i, j := 0, len(want)-1; i < j; i, j = i+1, j-1 {
+			want[i], want[j] = want[j], want[i]
+		}
+	}
+	swap := func(b int) { want[0], want[b%len(want)] = want[b%len(want)], want[0] }
+	reverse()
+	swap(1)
+	want = want[1:]
+	swap(35)
+	reverse()
+
+	got, err := player.decipher(context.Background(), s)
+	if err != nil || got != string(want) {
+		t.Errorf("decipher() = %s, %v, want %s", got, err, want)
+	}
+
+	code, name, err := extractSignatureCode(js)
+	if err != nil || name != "lma" || !strings.HasPrefix(code, "var aL={") {
+		t.Errorf("extractSignatureCode() = %s, %s, %v", code, name, err)
+	}
+}
+
+// playerVectors structure containing known input and output pairs
+// of the signature and n functions of a player
+type playerVectors struct {
+	Signatures [][2]string `json:"signatures"`
+	N          [][2]string `json:"n"`
+}
+
+// TestPlayerVectors checks every player of testdata/player having a
+// vectors.json, a trimmed base.js of a real player goes there with
+// the pairs given by another implementation such as yt-dlp
+func TestPlayerVectors(t *testing.T) {
+	paths, _ := filepath.Glob(filepath.Join("testdata", "player", "*", "vectors.json"))
+	if len(paths) == 0 {
+		t.Fatal("no player vectors")
+	}
+
+	for _, path := range paths {
+		dir := filepath.Dir(path)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var vectors playerVectors
+		if err := json.Unmarshal(data, &vectors); err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+
+		js, err := ioutil.ReadFile(filepath.Join(dir, "base.js"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		player, err := newPlayer("https://www.youtube.com/s/player/"+filepath.Base(dir)+"/player_ias.vflset/en_US/base.js", string(js))
+		if err != nil {
+			t.Errorf("%s: %s", dir, err)
+			continue
+		}
+
+		for _, pair := range vectors.Signatures {
+			if got, err := player.decipher(context.Background(), pair[0]); err != nil || got != pair[1] {
+				t.Errorf("%s: decipher(%s) = %s, %v, want %s", dir, pair[0], got, err, pair[1])
+			}
+		}
+		for _, pair := range vectors.N {
+			if got, err := player.transformN(context.Background(), pair[0]); err != nil || got != pair[1] {
+				t.Errorf("%s: transformN(%s) = %s, %v, want %s", dir, pair[0], got, err, pair[1])
+			}
+		}
+	}
+}
+
+func TestTransformN(t *testing.T) {
+	player, js := loadFixturePlayer(t)
+
+	code, name, err := extractNCode(js)
+	if err != nil || name != "vqa" {
+		t.Fatalf("extractNCode() = %s, %v", name, err)
+	}
+	if strings.Contains(code, "typeof Lx") {
+		t.Error("the guard of the n function wasn't removed")
+	}
+
+	got, err := player.transformN(context.Background(), "aBcDeFgHiJkLmN")
+	if err != nil || got != "guBZfAyFJwCCuh" {
+		t.Errorf("transformN() = %s, %v", got, err)
+	}
+
+	// the n function reports its failures in its result
+	broken, err := newPlayer(fixturePlayerURL, strings.Replace(js, `c[4](b,"kX9",c[3])`, `c[4](b,"kX9",null)`, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := broken.transformN(context.Background(), "aBcDeFgHiJkLmN"); err == nil {
+		t.Error("failure of the n function ignored")
+	}
+
+	// players without n function still decipher signatures
+	old, err := newPlayer(fixturePlayerURL, strings.Replace(js, `.get("n")`, `.get("m")`, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.transformN(context.Background(), "aBcDeFgHiJkLmN"); err != errNoNFunction {
+		t.Errorf("transformN() without n function = %v", err)
+	}
+}
+
+func TestPlayerCallTimeout(t *testing.T) {
+	_, js := loadFixturePlayer(t)
+	defer func(timeout time.Duration) { playerCallTimeout = timeout }(playerCallTimeout)
+	playerCallTimeout = 50 * time.Millisecond
+
+	looping, err := newPlayer(fixturePlayerURL, strings.Replace(js, `try{c[2](b,7)`, `try{for(;;){}c[2](b,7)`, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := looping.transformN(context.Background(), "aBcDeFgHiJkLmN"); err != context.DeadlineExceeded {
+		t.Errorf("transformN() of a looping player = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := looping.transformN(ctx, "aBcDeFgHiJkLmN"); err != context.Canceled {
+		t.Errorf("transformN() with a canceled context = %v", err)
+	}
+
+	// the interpreter is usable again
+	if _, err := looping.decipher(context.Background(), "AOq0QJ8wRQIhAMb8cEvZzdKkYfJ9yDlQ1vXf0rMn3"); err != nil {
+		t.Errorf("decipher() after an interrupted call = %v", err)
+	}
+}
+
+func TestDecipherURL(t *testing.T) {
+	player, _ := loadFixturePlayer(t)
+
+	got, err := player.decipherURL(context.Background(), "https://r1.googlevideo.com/videoplayback?itag=251&n=aBcDeFgHiJkLmN", "ABC", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "https://r1.googlevideo.com/videoplayback?itag=251&n=guBZfAyFJwCCuh&signature=CA" {
+		t.Errorf("decipherURL() = %s", got)
+	}
+}
+
+// countingTripper counts the requests to the fixtures
+type countingTripper struct {
+	Paths map[string]int
+}
+
+func (t countingTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.Paths[req.URL.Path]++
+	return fixtureTripper{}.RoundTrip(req)
+}
+
+// resetPlayers forgets the players loaded and the failures
+func resetPlayers() {
+	players = make(map[string]*Player)
+	playerFailures = make(map[string]playerFailure)
+}
+
+func TestLoadPlayer(t *testing.T) {
+	defer useFixtures(t)()
+	defer resetPlayers()
+	resetPlayers()
+
+	tripper := countingTripper{Paths: make(map[string]int)}
+	directTripper = tripper
+
+	// without the watch page, the player is found with the iframe API
+	for i := 0; i < 3; i++ {
+		player, err := loadPlayer(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if player.URL != fixturePlayerURL {
+			t.Errorf("player URL = %s", player.URL)
+		}
+	}
+	if n := tripper.Paths["/s/player/4fbb4d5b/player_ias.vflset/en_US/base.js"]; n != 1 {
+		t.Errorf("base.js fetched %d times", n)
+	}
+
+	// a player that can't be loaded isn't fetched again for a while
+	for i := 0; i < 3; i++ {
+		if _, err := loadPlayer(context.Background(), "https://www.youtube.com/s/player/missing/base.js"); err == nil {
+			t.Error("missing player loaded")
+		}
+	}
+	if n := tripper.Paths["/s/player/missing/base.js"]; n != 1 {
+		t.Errorf("missing base.js fetched %d times", n)
+	}
+
+	playerFailures["https://www.youtube.com/s/player/missing/base.js"] = playerFailure{at: time.Now().Add(-playerFailureTTL)}
+	loadPlayer(context.Background(), "https://www.youtube.com/s/player/missing/base.js")
+	if n := tripper.Paths["/s/player/missing/base.js"]; n != 2 {
+		t.Errorf("missing base.js fetched %d times once the failure expired", n)
+	}
+}
+
+func TestDecipherFormatsOptional(t *testing.T) {
+	defer useFixtures(t)()
+	defer resetPlayers()
+	resetPlayers()
+
+	video := new(Video)
+	video.RawHTML = `"jsUrl":"/s/player/missing/player_ias.vflset/en_US/base.js"`
+	video.InfoJSON.Formats = []Format{{URL: "https://r1.googlevideo.com/videoplayback?itag=251", signature: "ABC"}}
+
+	if err := decipherFormats(context.Background(), video); err == nil {
+		t.Error("required formats without player")
+	}
+
+	delete(arguments.RequiredFields, fieldFormats)
+	if err := decipherFormats(context.Background(), video); err != nil {
+		t.Errorf("decipherFormats() = %v with optional formats", err)
+	}
+	if video.FieldStatus[fieldFormats] == "" || video.FieldStatus[fieldFormats] == fieldOK {
+		t.Errorf("status of the formats = %q", video.FieldStatus[fieldFormats])
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// The cookie telling YouTube we refused the tracking cookies, so
+// that it stops sending EU visitors to consent.youtube.com
+const (
+	consentDomain      = ".youtube.com"
+	consentCookieName  = "SOCS"
+	consentCookieValue = "CAI"
+)
+
+var youtubeURL = &url.URL{Scheme: "https", Host: "www.youtube.com", Path: "/"}
+
+var errConsentRequired = errors.New("YouTube sent the consent page instead of the video, cancelation")
+
+// httpOnlyPrefix marks the HttpOnly cookies in a cookies.txt file
+const httpOnlyPrefix = "#HttpOnly_"
+
+// CookieJar is a cookie jar that remembers every cookie it
+// gets, so that they can be written back to a cookies.txt file
+type CookieJar struct {
+	lock    sync.Mutex
+	jar     *cookiejar.Jar
+	cookies map[string]*http.Cookie
+	// cookies given without Domain only match the host that set them
+	hostOnly map[string]bool
+}
+
+func newCookieJar() *CookieJar {
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return &CookieJar{
+		jar:      jar,
+		cookies:  make(map[string]*http.Cookie),
+		hostOnly: make(map[string]bool),
+	}
+}
+
+// cookieKey identifies a cookie by its domain, path and name
+func cookieKey(c *http.Cookie) string {
+	return c.Domain + ";" + c.Path + ";" + c.Name
+}
+
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	now := time.Now()
+	for _, c := range cookies {
+		saved := *c
+		hostOnly := saved.Domain == ""
+		if hostOnly {
+			saved.Domain = u.Hostname()
+		}
+		saved.Domain = strings.ToLower(strings.TrimPrefix(saved.Domain, "."))
+		if saved.Path == "" || saved.Path[0] != '/' {
+			saved.Path = "/"
+		}
+		if saved.MaxAge > 0 {
+			saved.Expires = now.Add(time.Duration(saved.MaxAge) * time.Second)
+		}
+
+		key := cookieKey(&saved)
+		if saved.MaxAge < 0 || (!saved.Expires.IsZero() && saved.Expires.Before(now)) {
+			delete(j.cookies, key)
+			delete(j.hostOnly, key)
+			continue
+		}
+		j.cookies[key] = &saved
+		j.hostOnly[key] = hostOnly
+	}
+}
+
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// has tells if the jar holds the cookie for the domain
+func (j *CookieJar) has(domain string, name string) bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	domain = strings.TrimPrefix(domain, ".")
+	for _, c := range j.cookies {
+		if c.Name == name && c.Domain == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// consentCookie returns the consent cookie, valid for a year
+func consentCookie() *http.Cookie {
+	return &http.Cookie{
+		Name:    consentCookieName,
+		Value:   consentCookieValue,
+		Domain:  consentDomain,
+		Path:    "/",
+		Secure:  true,
+		Expires: time.Now().AddDate(1, 0, 0),
+	}
+}
+
+// setConsent gives the consent cookie to YouTube, unless
+// the jar already has one, from a cookies.txt file maybe
+func (j *CookieJar) setConsent() {
+	if j.has(consentDomain, consentCookieName) {
+		return
+	}
+	j.SetCookies(youtubeURL, []*http.Cookie{consentCookie()})
+}
+
+// readCookiesTxt reads the cookies of a Netscape cookies.txt
+// file, as exported by browsers and youtube-dl, into the jar
+func (j *CookieJar) readCookiesTxt(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		httpOnly := strings.HasPrefix(text, httpOnlyPrefix)
+		if httpOnly {
+			text = strings.TrimPrefix(text, httpOnlyPrefix)
+		}
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, "\t")
+		if len(fields) == 6 {
+			// cookies with an empty value lose their last tab
+			fields = append(fields, "")
+		}
+		if len(fields) != 7 {
+			return errors.New("invalid cookie on line " + strconv.Itoa(line) + ", expected 7 tab-separated fields")
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return errors.New("invalid expiration on line " + strconv.Itoa(line) + ": " + fields[4])
+		}
+
+		domain := strings.TrimPrefix(fields[0], ".")
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		if strings.EqualFold(fields[1], "TRUE") {
+			cookie.Domain = domain
+		}
+		// 0 is a session cookie, kept until the end of the run
+		if expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+		}
+
+		j.SetCookies(&url.URL{Scheme: "https", Host: domain, Path: cookie.Path}, []*http.Cookie{cookie})
+	}
+	return scanner.Err()
+}
+
+// writeCookiesTxt writes the cookies of the jar in the Netscape format
+func (j *CookieJar) writeCookiesTxt(w io.Writer) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	var keys []string
+	for key := range j.cookies {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	_, err := fmt.Fprint(w, "# Netscape HTTP Cookie File\n\n")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		c := j.cookies[key]
+		if !c.Expires.IsZero() && c.Expires.Before(now) {
+			continue
+		}
+
+		domain, includeSubdomains := c.Domain, "FALSE"
+		if !j.hostOnly[key] {
+			domain, includeSubdomains = "."+c.Domain, "TRUE"
+		}
+		if c.HttpOnly {
+			domain = httpOnlyPrefix + domain
+		}
+		var expires int64
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Unix()
+		}
+		secure := "FALSE"
+		if c.Secure {
+			secure = "TRUE"
+		}
+
+		_, err = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", domain, includeSubdomains, c.Path, secure, expires, c.Name, c.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadCookies reads the cookies.txt file, a missing file is an empty jar
+func (j *CookieJar) loadCookies(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return j.readCookiesTxt(f)
+}
+
+// saveCookies writes the jar back to the cookies.txt file, through
+// a temporary file so that an interrupted run doesn't lose them
+func (j *CookieJar) saveCookies(path string) error {
+	var buffer bytes.Buffer
+	err := j.writeCookiesTxt(&buffer)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buffer.Bytes(), 0600)
+}
+
+// isConsentPage tells if the URL is the EU consent interstitial
+func isConsentPage(u *url.URL) bool {
+	return u != nil && (u.Hostname() == "consent.youtube.com" || u.Hostname() == "consent.google.com")
+}
+
+type consentRetryKey struct{}
+
+// isConsentRedirect tells if the response sends to the consent page
+func isConsentRedirect(res *http.Response) bool {
+	if res.StatusCode < 300 || res.StatusCode >= 400 {
+		return false
+	}
+	location, err := res.Location()
+	return err == nil && isConsentPage(location)
+}
+
+// retriedForConsent tells if the response sends to the consent page a
+// request ConsentTripper is going to send again, with the consent cookie
+func retriedForConsent(req *http.Request, res *http.Response) bool {
+	retry, _ := req.Context().Value(consentRetryKey{}).(bool)
+	return retry && isConsentRedirect(res)
+}
+
+// ConsentTripper gives the consent cookie and sends the request
+// again when YouTube redirects it to the consent page anyway
+type ConsentTripper struct {
+	Jar     *CookieJar
+	Tripper http.RoundTripper
+}
+
+func (t *ConsentTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Tell the rate limiter below that a redirect to the consent
+	// page isn't YouTube pushing back, the request is sent again
+	first := req
+	if !isConsentPage(req.URL) && (req.Body == nil || req.GetBody != nil) {
+		first = req.WithContext(context.WithValue(req.Context(), consentRetryKey{}, true))
+	}
+
+	res, err := t.Tripper.RoundTrip(first)
+	if err != nil || isConsentPage(req.URL) || !isConsentRedirect(res) {
+		return res, err
+	}
+
+	// The cookie we had wasn't enough, replace it
+	t.Jar.SetCookies(youtubeURL, []*http.Cookie{consentCookie()})
+
+	if !retriedForConsent(first, res) {
+		return res, nil
+	}
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return res, nil
+		}
+	}
+	retry.Header.Del("Cookie")
+	for _, c := range t.Jar.Cookies(retry.URL) {
+		retry.AddCookie(c)
+	}
+
+	res.Body.Close()
+	return t.Tripper.RoundTrip(retry)
+}
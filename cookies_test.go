@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const cookiesTxt = `# Netscape HTTP Cookie File
+# comment
+
+.youtube.com	TRUE	/	TRUE	4102444800	PREF	hl=en
+#HttpOnly_.youtube.com	TRUE	/	TRUE	4102444800	LOGIN_INFO	secret
+www.youtube.com	FALSE	/feed	FALSE	0	session	1
+.youtube.com	TRUE	/	FALSE	946684800	expired	1
+.youtube.com	TRUE	/	FALSE	4102444800	empty
+`
+
+func TestCookiesTxt(t *testing.T) {
+	jar := newCookieJar()
+	if err := jar.readCookiesTxt(strings.NewReader(cookiesTxt)); err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(u string) string {
+		parsed, _ := url.Parse(u)
+		var names []string
+		for _, c := range jar.Cookies(parsed) {
+			names = append(names, c.Name)
+		}
+		return strings.Join(names, ",")
+	}
+	if got := names("https://m.youtube.com/watch"); got != "PREF,LOGIN_INFO,empty" {
+		t.Errorf("cookies of m.youtube.com = %s", got)
+	}
+	if got := names("https://www.youtube.com/feed/subscriptions"); !strings.Contains(got, "session") {
+		t.Errorf("cookies of www.youtube.com/feed = %s", got)
+	}
+	if got := names("http://www.youtube.com/"); got != "empty" {
+		t.Errorf("cookies over http = %s", got)
+	}
+
+	// written back without the expired cookie, the same way
+	var out bytes.Buffer
+	if err := jar.writeCookiesTxt(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(cookiesTxt, "\n") {
+		if strings.HasPrefix(line, "# comment") || strings.Contains(line, "expired") {
+			continue
+		}
+		if line == ".youtube.com\tTRUE\t/\tFALSE\t4102444800\tempty" {
+			line += "\t"
+		}
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("%q missing from\n%s", line, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "expired") {
+		t.Errorf("expired cookie written:\n%s", out.String())
+	}
+
+	if err := newCookieJar().readCookiesTxt(strings.NewReader(".youtube.com\tTRUE\t/\n")); err == nil {
+		t.Error("truncated line accepted")
+	}
+}
+
+func TestConsentCookie(t *testing.T) {
+	jar := newCookieJar()
+	jar.setConsent()
+	if cookies := jar.Cookies(youtubeURL); len(cookies) != 1 || cookies[0].Value != consentCookieValue {
+		t.Errorf("cookies = %v", cookies)
+	}
+
+	// a consent given in the browser is kept
+	jar = newCookieJar()
+	jar.readCookiesTxt(strings.NewReader(".youtube.com\tTRUE\t/\tTRUE\t4102444800\tSOCS\tbrowser\n"))
+	jar.setConsent()
+	if cookies := jar.Cookies(youtubeURL); len(cookies) != 1 || cookies[0].Value != "browser" {
+		t.Errorf("cookies = %v", cookies)
+	}
+}
+
+func TestConsentTripper(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if c, err := r.Cookie(consentCookieName); err != nil || c.Value != consentCookieValue {
+			http.Redirect(w, r, "https://consent.youtube.com/m?continue="+url.QueryEscape(r.URL.String()), http.StatusFound)
+			return
+		}
+		w.Write([]byte("watch page"))
+	}))
+	defer server.Close()
+
+	// the test server stands for www.youtube.com
+	jar := newCookieJar()
+	limits := newRateLimits(0, 4, nil)
+	tripper := &ConsentTripper{Jar: jar, Tripper: &RateLimitTripper{Limits: limits, Tripper: rewriteTripper{server.URL, http.DefaultTransport}}}
+	client := &http.Client{Transport: tripper, Jar: jar}
+
+	res, err := client.Get("https://www.youtube.com/watch?v=MPBfVp0tB8E")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || requests != 2 {
+		t.Errorf("status %d after %d requests", res.StatusCode, requests)
+	}
+
+	// the redirect was handled, it didn't slow YouTube down
+	if limit := limits.host("www.youtube.com").limiter.Limit(); limit != 4 {
+		t.Errorf("limit = %v after the consent page", limit)
+	}
+
+	// the cookie is sent from now on
+	requests = 0
+	res, err = client.Get("https://www.youtube.com/watch?v=MPBfVp0tB8E")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if requests != 1 {
+		t.Errorf("%d requests with the consent cookie", requests)
+	}
+	if !jar.has(consentDomain, consentCookieName) {
+		t.Error("consent cookie not kept")
+	}
+}
+
+// rewriteTripper sends the https requests to the test server
+type rewriteTripper struct {
+	URL     string
+	Tripper http.RoundTripper
+}
+
+func (t rewriteTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, _ := url.Parse(t.URL)
+	rewritten := req.Clone(req.Context())
+	rewritten.URL.Scheme = target.Scheme
+	rewritten.URL.Host = target.Host
+	return t.Tripper.RoundTrip(rewritten)
+}
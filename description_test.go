@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestParseDescription(t *testing.T) {
+	video, document := loadFixture(t, legacyFixtureID)
+	if err := parseDescription(video, document); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "First line of the description\nhttps://example.com\nLast line"
+	if video.Description != want {
+		t.Errorf("description = %q, want %q", video.Description, want)
+	}
+}
+
+func TestParseDescriptionUnknownElement(t *testing.T) {
+	document, err := goquery.NewDocumentFromReader(strings.NewReader(`<p id="eow-description">text<img src="x"></p>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := parseDescription(new(Video), document); err == nil {
+		t.Error("expected an error on an unknown element")
+	}
+}
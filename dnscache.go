@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// dnsEntry is the addresses of a host and when they expire
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// dnsCache structure containing the resolved host names, so that
+// thousands of requests to the same hosts don't hit the resolver
+type dnsCache struct {
+	lock    sync.Mutex
+	TTL     time.Duration
+	entries map[string]dnsEntry
+	lookup  func(ctx context.Context, host string) ([]string, error)
+}
+
+func newDNSCache(ttl time.Duration) *dnsCache {
+	return &dnsCache{
+		TTL:     ttl,
+		entries: make(map[string]dnsEntry),
+		lookup:  net.DefaultResolver.LookupHost,
+	}
+}
+
+// resolve returns the addresses of the host, from the cache while
+// they're fresh, failed lookups aren't cached
+func (c *dnsCache) resolve(ctx context.Context, host string) ([]string, error) {
+	now := time.Now()
+
+	c.lock.Lock()
+	entry, ok := c.entries[host]
+	c.lock.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.addrs, nil
+	}
+
+	addrs, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no address found for " + host)
+	}
+
+	c.lock.Lock()
+	c.entries[host] = dnsEntry{addrs: addrs, expires: now.Add(c.TTL)}
+	c.lock.Unlock()
+	return addrs, nil
+}
+
+// dialer wraps dial so that it connects to the cached
+// addresses, trying each of them in turn
+func (c *dnsCache) dialer(dial func(ctx context.Context, network, address string) (net.Conn, error)) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) != nil {
+			return dial(ctx, network, address)
+		}
+
+		addrs, err := c.resolve(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, addr := range addrs {
+			var conn net.Conn
+			conn, err = dial(ctx, network, net.JoinHostPort(addr, port))
+			if err == nil {
+				return conn, nil
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, err
+	}
+}
+
+// lookupHost returns the first address of the host,
+// through the DNS cache when there is one
+func lookupHost(ctx context.Context, host string) (string, error) {
+	if resolver != nil {
+		addrs, err := resolver.resolve(ctx, host)
+		if err != nil {
+			return "", err
+		}
+		return addrs[0], nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", errors.New("no address found for " + host)
+	}
+	return addrs[0], nil
+}
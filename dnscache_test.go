@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDNSCache(t *testing.T) {
+	lookups := 0
+	cache := newDNSCache(time.Hour)
+	cache.lookup = func(ctx context.Context, host string) ([]string, error) {
+		lookups++
+		if host == "broken.example.com" {
+			return nil, errors.New("no such host")
+		}
+		return []string{"192.0.2.1", "127.0.0.1"}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		addrs, err := cache.resolve(context.Background(), "www.youtube.com")
+		if err != nil || len(addrs) != 2 {
+			t.Fatalf("resolve() = %v, %v", addrs, err)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("%d lookups for a cached host", lookups)
+	}
+
+	// failures aren't cached
+	cache.resolve(context.Background(), "broken.example.com")
+	cache.resolve(context.Background(), "broken.example.com")
+	if lookups != 3 {
+		t.Errorf("%d lookups after two failures", lookups)
+	}
+
+	// expired entries are resolved again
+	cache.entries["www.youtube.com"] = dnsEntry{addrs: []string{"192.0.2.1"}, expires: time.Now().Add(-time.Second)}
+	cache.resolve(context.Background(), "www.youtube.com")
+	if lookups != 4 {
+		t.Errorf("%d lookups after expiry", lookups)
+	}
+}
+
+func TestDNSCacheDialer(t *testing.T) {
+	cache := newDNSCache(time.Hour)
+	cache.lookup = func(ctx context.Context, host string) ([]string, error) {
+		return []string{"192.0.2.1", "127.0.0.1"}, nil
+	}
+
+	var dialed []string
+	dial := cache.dialer(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		if address == "192.0.2.1:443" {
+			return nil, errors.New("connection refused")
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	})
+
+	conn, err := dial(context.Background(), "tcp", "www.youtube.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if len(dialed) != 2 || dialed[1] != "127.0.0.1:443" {
+		t.Errorf("dialed %v", dialed)
+	}
+}
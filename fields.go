@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// Fields of infoJSON whose extraction is tracked in the .status.json file
+const (
+	fieldTitle         = "title"
+	fieldDescription   = "description"
+	fieldUploader      = "uploader"
+	fieldUploadDate    = "upload_date"
+	fieldLicense       = "license"
+	fieldViewCount     = "view_count"
+	fieldLikes         = "likes"
+	fieldAverageRating = "average_rating"
+	fieldFormats       = "formats"
+	fieldTags          = "tags"
+	fieldCategory      = "category"
+	fieldAgeLimit      = "age_limit"
+	fieldDuration      = "duration"
+)
+
+var knownFields = []string{
+	fieldTitle, fieldDescription, fieldUploader, fieldUploadDate,
+	fieldLicense, fieldViewCount, fieldLikes, fieldAverageRating,
+	fieldFormats, fieldTags, fieldCategory, fieldAgeLimit, fieldDuration,
+}
+
+// Fields without which a video isn't archived, the others are best-effort
+const defaultRequiredFields = "title,uploader,upload_date,formats"
+
+// Status of a field that has been extracted
+const fieldOK = "ok"
+
+// errFieldMissing is the status of a best-effort field absent from the page
+var errFieldMissing = errors.New("missing")
+
+// fieldParser extracts one field of the video
+type fieldParser struct {
+	Field string
+	Parse func() error
+}
+
+// missingIf returns errFieldMissing if the field wasn't found
+func missingIf(missing bool) error {
+	if missing {
+		return errFieldMissing
+	}
+	return nil
+}
+
+// parseRequiredFields reads the comma-separated list of --required-fields
+func parseRequiredFields(list string) (map[string]bool, error) {
+	required := make(map[string]bool)
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		known := false
+		for _, knownField := range knownFields {
+			if field == knownField {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, errors.New("unknown field " + field + ", valid fields are " + strings.Join(knownFields, ","))
+		}
+		required[field] = true
+	}
+	return required, nil
+}
+
+// extractFields runs every parser and records its status in
+// video.FieldStatus, only the failure of a required field stops
+// the extraction
+func extractFields(video *Video, parsers []fieldParser) error {
+	if video.FieldStatus == nil {
+		video.FieldStatus = make(map[string]string)
+	}
+
+	for _, parser := range parsers {
+		err := parser.Parse()
+		if err == nil {
+			video.FieldStatus[parser.Field] = fieldOK
+			continue
+		}
+
+		video.FieldStatus[parser.Field] = err.Error()
+		if arguments.RequiredFields[parser.Field] {
+			return err
+		}
+	}
+	return nil
+}
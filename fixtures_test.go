@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Video IDs of the recorded pages in testdata/watch
+const (
+	legacyFixtureID = "legacyVid01"
+	modernFixtureID = "modernVid01"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata/golden")
+
+// fixtureTripper serves the files of testdata instead of the network
+type fixtureTripper struct{}
+
+// fixturePath maps a request to its recorded file
+func fixturePath(u *url.URL) string {
+	q := u.Query()
+	switch {
+	case u.Path == "/watch":
+		return filepath.Join("testdata", "watch", q.Get("v")+".html")
+	case u.Path == "/timedtext" && q.Get("type") == "list":
+		return filepath.Join("testdata", "timedtext", q.Get("v")+".list.xml")
+	case u.Path == "/api/timedtext" && q.Get("fmt") == "":
+		return filepath.Join("testdata", "timedtext", q.Get("v")+"."+q.Get("lang")+".xml")
+	case u.Path == "/iframe_api":
+		return filepath.Join("testdata", "player", "iframe_api.js")
+	case strings.HasPrefix(u.Path, "/s/player/"):
+		return filepath.Join("testdata", "player", strings.Split(u.Path, "/")[3], "base.js")
+	case strings.HasPrefix(u.Path, "/vi/"):
+		return filepath.Join("testdata", "thumbnails", strings.Split(u.Path, "/")[2]+".jpg")
+	}
+	return ""
+}
+
+func (fixtureTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+
+	data, err := ioutil.ReadFile(fixturePath(req.URL))
+	if err != nil {
+		res.StatusCode = http.StatusNotFound
+		data = nil
+	}
+	res.Status = http.StatusText(res.StatusCode)
+	res.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return res, nil
+}
+
+// useFixtures makes every HTTP request hit testdata and writes the
+// archives to a temporary directory, the returned func restores everything
+func useFixtures(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "youtube-ma")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldTripper, oldArguments, oldLogFileName := directTripper, arguments, logFileName
+	directTripper = fixtureTripper{}
+	arguments.Output = filepath.Join(dir, "videos")
+	arguments.Timeout = time.Minute
+	arguments.Secret = ""
+	arguments.InnerTube = false
+	arguments.Record = ""
+	arguments.Replay = ""
+	arguments.RequiredFields, _ = parseRequiredFields(defaultRequiredFields)
+	arguments.DirTemplate = defaultDirTemplate
+	arguments.FileTemplate = defaultFileTemplate
+	arguments.Refresh = false
+	logFileName = filepath.Join(dir, "test.log")
+
+	return func() {
+		directTripper, arguments, logFileName = oldTripper, oldArguments, oldLogFileName
+		os.RemoveAll(dir)
+	}
+}
+
+// loadFixture returns the video and the document of a recorded watch page
+func loadFixture(t *testing.T, ID string) (*Video, *goquery.Document) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "watch", ID+".html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	video := new(Video)
+	video.ID = ID
+	video.RawHTML = string(data)
+	video.InfoJSON.Subtitles = make(map[string][]Subtitle)
+	video.playerArgs = make(map[string]interface{})
+
+	document, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return video, document
+}
+
+// checkGolden compares got with testdata/golden/name, or
+// overwrites it when running with -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	path := filepath.Join("testdata", "golden", name)
+	if *update {
+		err := ioutil.WriteFile(path, got, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file, run go test -update if this is expected\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
@@ -64,8 +64,6 @@ func parseFormats(video *Video) error {
 					v[0] == "315" {
 					tmpFormat.FormatNote = "DASH video"
 					tmpFormat.Format = tmpFormat.FormatID + " - " + tmpFormat.FormatNote
-				} else {
-					tmpFormat.Format = tmpFormat.FormatID + " - " + tmpFormat.Type
 				}
 			case "lmt":
 				tmpFormat.Lmt, _ = strconv.ParseFloat(v[0], 64)
@@ -76,17 +74,30 @@ func parseFormats(video *Video) error {
 			case "size":
 				tmpFormat.Size = v[0]
 				sizes := strings.Split(v[0], "x")
-				tmpFormat.Width, _ = strconv.ParseFloat(sizes[0], 64)
-				tmpFormat.Height, _ = strconv.ParseFloat(sizes[1], 64)
+				if len(sizes) == 2 {
+					tmpFormat.Width, _ = strconv.ParseFloat(sizes[0], 64)
+					tmpFormat.Height, _ = strconv.ParseFloat(sizes[1], 64)
+				}
 			case "type":
 				tmpFormat.Type = v[0]
+				// "video/mp4; codecs=..." gives mp4
 				s := strings.Index(v[0], "/")
 				e := strings.Index(v[0], ";")
-				tmpFormat.Ext = v[0][s+1 : e]
+				if s >= 0 && e > s {
+					tmpFormat.Ext = v[0][s+1 : e]
+				}
 			case "url":
 				tmpFormat.URL = v[0]
+			case "s":
+				tmpFormat.signature = v[0]
+			case "sp":
+				tmpFormat.signatureParam = v[0]
 			}
 		}
+		// The type may come after the itag
+		if tmpFormat.Format == "" {
+			tmpFormat.Format = tmpFormat.FormatID + " - " + tmpFormat.Type
+		}
 		video.InfoJSON.Formats = append(video.InfoJSON.Formats, tmpFormat)
 	}
 
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseFormats(t *testing.T) {
+	video := legacyWithPlayerArgs(t)
+	if err := parseFormats(video); err != nil {
+		t.Fatal(err)
+	}
+
+	formats := video.InfoJSON.Formats
+	if len(formats) != 2 {
+		t.Fatalf("got %d formats", len(formats))
+	}
+
+	videoFormat := formats[0]
+	if videoFormat.FormatID != "137" || videoFormat.Ext != "mp4" || videoFormat.FormatNote != "DASH video" {
+		t.Errorf("video format = %+v", videoFormat)
+	}
+	if videoFormat.Width != 1920 || videoFormat.Height != 1080 || videoFormat.Fps != 30 {
+		t.Errorf("video size = %vx%v@%v", videoFormat.Width, videoFormat.Height, videoFormat.Fps)
+	}
+	if videoFormat.URL != "https://r2.googlevideo.com/videoplayback?itag=137&id=legacy" {
+		t.Errorf("video URL = %q", videoFormat.URL)
+	}
+
+	audioFormat := formats[1]
+	if audioFormat.FormatID != "140" || audioFormat.Ext != "mp4" || audioFormat.FormatNote != "DASH audio" || audioFormat.Clen != 3000000 {
+		t.Errorf("audio format = %+v", audioFormat)
+	}
+}
+
+func TestParseFormatsEmpty(t *testing.T) {
+	video := new(Video)
+	video.playerArgs = make(map[string]interface{})
+	if err := parseFormats(video); err == nil {
+		t.Error("expected an error without formats")
+	}
+}
+
+func TestParseFormatsMalformed(t *testing.T) {
+	video := new(Video)
+	video.playerArgs = map[string]interface{}{
+		"adaptive_fmts": "itag=137&type=video%2Fmp4&size=1920,itag=140&type=audio&size=x",
+	}
+	if err := parseFormats(video); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range video.InfoJSON.Formats {
+		if format.Ext != "" {
+			t.Errorf("format %s: Ext = %q, want none", format.FormatID, format.Ext)
+		}
+		if format.Width != 0 || format.Height != 0 {
+			t.Errorf("format %s: size = %vx%v", format.FormatID, format.Width, format.Height)
+		}
+	}
+}
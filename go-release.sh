@@ -6,21 +6,21 @@ tag=$2
 [ -z "$appname" ] && echo "Usage: build <appname> <version>" && exit 1
 
 name=${appname}-${tag}-windows.exe
-GOOS="windows" GOARCH="amd64" go build -ldflags="-s -w" -o $name
+GOOS="windows" GOARCH="amd64" go build -ldflags="-s -w -X main.version=${tag}" -o $name
 gzip -f $name
 echo $name
 
 name=${appname}-${tag}-linux
-GOOS="linux" GOARCH="amd64" go build -ldflags="-s -w" -o $name
+GOOS="linux" GOARCH="amd64" go build -ldflags="-s -w -X main.version=${tag}" -o $name
 gzip -f $name
 echo $name
 
 name=${appname}-${tag}-osx
-GOOS="darwin" GOARCH="amd64" go build -ldflags="-s -w" -o $name
+GOOS="darwin" GOARCH="amd64" go build -ldflags="-s -w -X main.version=${tag}" -o $name
 gzip -f $name
 echo $name
 
 name=${appname}-${tag}-freebsd
-GOOS="freebsd" GOARCH="amd64" go build -ldflags="-s -w" -o $name
+GOOS="freebsd" GOARCH="amd64" go build -ldflags="-s -w -X main.version=${tag}" -o $name
 gzip -f $name
 echo $name
\ No newline at end of file
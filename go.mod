@@ -1,13 +1,24 @@
 module github.com/CorentinB/YouTube-MA
 
-go 1.12
+go 1.19
 
 require (
 	github.com/PuerkitoBio/goquery v1.5.0
 	github.com/akamensky/argparse v1.1.0
 	github.com/deckarep/golang-set v1.7.1
-	github.com/labstack/gommon v0.3.0
-	github.com/remeh/sizedwaitgroup v1.0.0
+	github.com/dop251/goja v0.0.0-20230605162241-28ee0ee714f3
 	github.com/spf13/cast v1.3.0
-	golang.org/x/net v0.0.0-20191207000613-e7e4b65ae663
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/net v0.17.0
+	golang.org/x/time v0.0.0-20190921001708-c4c64cad1fd0
+)
+
+require (
+	github.com/andybalholm/cascadia v1.0.0 // indirect
+	github.com/dlclark/regexp2 v1.7.0 // indirect
+	github.com/go-sourcemap/sourcemap v2.1.3+incompatible // indirect
+	github.com/google/pprof v0.0.0-20230207041349-798e818bf904 // indirect
+	github.com/stretchr/testify v1.4.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
 )
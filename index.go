@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Each archived video has a file named after its ID in this directory
+// of the output, holding the path of its manifest, so that archived
+// videos are found without their metadata whatever the templates are
+const indexDirName = ".index"
+
+// indexPath returns the path of the index entry of the video
+func indexPath(ID string) string {
+	name := sanitizeFileName(ID, maxFileNameBytes)
+	shard := name
+	if len(shard) > 2 {
+		shard = shard[:2]
+	}
+	return arguments.Output + "/" + indexDirName + "/" + shard + "/" + name
+}
+
+// writeIndex records where the manifest of the video is
+func writeIndex(ID string, manifestPath string) error {
+	rel, err := filepath.Rel(arguments.Output, manifestPath)
+	if err != nil {
+		return err
+	}
+
+	path := indexPath(ID)
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, []byte(filepath.ToSlash(rel)+"\n"), 0644)
+}
+
+// readIndex returns the path of the manifest of the video
+func readIndex(ID string) (string, error) {
+	data, err := ioutil.ReadFile(indexPath(ID))
+	if err != nil {
+		return "", err
+	}
+	rel := strings.TrimSpace(string(data))
+	if rel == "" {
+		return "", errors.New("empty index entry for " + ID)
+	}
+	return filepath.Join(arguments.Output, filepath.FromSlash(rel)), nil
+}
+
+// checkIndex returns an error if the index knows a complete
+// archive of the video, without rendering any path
+func checkIndex(video *Video) error {
+	path, err := readIndex(video.ID)
+	if err != nil {
+		return nil
+	}
+	manifest, err := readManifest(path)
+	if err != nil || manifest.ID != video.ID {
+		return nil
+	}
+	if checkManifest(filepath.Dir(path), manifest) == nil {
+		return errors.New("this video has already been archived")
+	}
+	return nil
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cast"
+)
+
+// PlayerResponse structure for the ytInitialPlayerResponse JSON
+type PlayerResponse struct {
+	PlayabilityStatus struct {
+		Status      string `json:"status"`
+		Reason      string `json:"reason"`
+		ErrorScreen struct {
+			PlayerErrorMessageRenderer struct {
+				Subreason interface{} `json:"subreason"`
+			} `json:"playerErrorMessageRenderer"`
+		} `json:"errorScreen"`
+	} `json:"playabilityStatus"`
+	StreamingData struct {
+		Formats         []StreamingFormat `json:"formats"`
+		AdaptiveFormats []StreamingFormat `json:"adaptiveFormats"`
+	} `json:"streamingData"`
+	VideoDetails struct {
+		VideoID          string   `json:"videoId"`
+		Title            string   `json:"title"`
+		LengthSeconds    string   `json:"lengthSeconds"`
+		Keywords         []string `json:"keywords"`
+		ChannelID        string   `json:"channelId"`
+		ShortDescription string   `json:"shortDescription"`
+		AverageRating    float64  `json:"averageRating"`
+		ViewCount        string   `json:"viewCount"`
+		Author           string   `json:"author"`
+	} `json:"videoDetails"`
+	Microformat struct {
+		PlayerMicroformatRenderer struct {
+			OwnerProfileURL   string `json:"ownerProfileUrl"`
+			OwnerChannelName  string `json:"ownerChannelName"`
+			ExternalChannelID string `json:"externalChannelId"`
+			Category          string `json:"category"`
+			PublishDate       string `json:"publishDate"`
+			UploadDate        string `json:"uploadDate"`
+			IsFamilySafe      *bool  `json:"isFamilySafe"`
+		} `json:"playerMicroformatRenderer"`
+	} `json:"microformat"`
+}
+
+// StreamingFormat structure for a format of the streamingData
+type StreamingFormat struct {
+	Itag            int    `json:"itag"`
+	URL             string `json:"url"`
+	SignatureCipher string `json:"signatureCipher"`
+	MimeType        string `json:"mimeType"`
+	Bitrate         int    `json:"bitrate"`
+	Width           int    `json:"width"`
+	Height          int    `json:"height"`
+	Fps             int    `json:"fps"`
+	ContentLength   string `json:"contentLength"`
+	LastModified    string `json:"lastModified"`
+	QualityLabel    string `json:"qualityLabel"`
+	InitRange       *struct {
+		Start string `json:"start"`
+		End   string `json:"end"`
+	} `json:"initRange"`
+	IndexRange *struct {
+		Start string `json:"start"`
+		End   string `json:"end"`
+	} `json:"indexRange"`
+	ColorInfo *struct {
+		Primaries               string `json:"primaries"`
+		TransferCharacteristics string `json:"transferCharacteristics"`
+	} `json:"colorInfo"`
+}
+
+// errNoInitialData is returned when the page doesn't embed
+// ytInitialPlayerResponse, meaning we got the legacy layout
+var errNoInitialData = errors.New("no ytInitialPlayerResponse in the page")
+
+// Assignments of the JSON objects embedded in the page
+var (
+	initialPlayerResponsePattern = jsonAssignmentPattern("ytInitialPlayerResponse")
+	initialDataPattern           = jsonAssignmentPattern("ytInitialData")
+)
+
+// jsonAssignmentPattern matches the assignment of an object to the
+// variable name, either declared with var or set on window
+func jsonAssignmentPattern(name string) *regexp.Regexp {
+	return regexp.MustCompile(`(?:var\s+|window\[['"])` + regexp.QuoteMeta(name) + `(?:['"]\])?\s*=\s*\{`)
+}
+
+// extractJSONObject returns the JSON object whose assignment
+// matches pattern in the page, or nil if there is none
+func extractJSONObject(page string, pattern *regexp.Regexp) []byte {
+	loc := pattern.FindStringIndex(page)
+	if loc == nil {
+		return nil
+	}
+
+	// Find the matching closing brace, ignoring the ones in strings
+	start := loc[1] - 1
+	depth := 0
+	inString := false
+	escaped := false
+	for i := start; i < len(page); i++ {
+		c := page[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return []byte(page[start : i+1])
+			}
+		}
+	}
+	return nil
+}
+
+// textOf returns the text of a {"simpleText"} or {"runs"} node
+func textOf(node interface{}) string {
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	if text, ok := m["simpleText"].(string); ok {
+		return text
+	}
+
+	var text string
+	runs, _ := m["runs"].([]interface{})
+	for _, run := range runs {
+		if r, ok := run.(map[string]interface{}); ok {
+			text += cast.ToString(r["text"])
+		}
+	}
+	return text
+}
+
+// walkRenderers calls fn on every object stored under the key,
+// at any depth of the node
+func walkRenderers(node interface{}, key string, fn func(map[string]interface{})) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for k, v := range n {
+			if k == key {
+				if renderer, ok := v.(map[string]interface{}); ok {
+					fn(renderer)
+				}
+			}
+			walkRenderers(v, key, fn)
+		}
+	case []interface{}:
+		for _, v := range n {
+			walkRenderers(v, key, fn)
+		}
+	}
+}
+
+// lookup follows the keys in nested objects
+func lookup(node interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		node = m[key]
+	}
+	return node
+}
+
+// streamingFormatToValues converts a format to the adaptive_fmts
+// representation so that parseFormats can handle both
+func streamingFormatToValues(format StreamingFormat) url.Values {
+	values := url.Values{}
+	values.Set("itag", strconv.Itoa(format.Itag))
+	values.Set("type", format.MimeType)
+	values.Set("bitrate", strconv.Itoa(format.Bitrate))
+
+	if format.URL != "" {
+		values.Set("url", format.URL)
+	} else if format.SignatureCipher != "" {
+		cipher, _ := url.ParseQuery(format.SignatureCipher)
+		for k, v := range cipher {
+			values[k] = v
+		}
+	}
+	if format.Width > 0 && format.Height > 0 {
+		values.Set("size", strconv.Itoa(format.Width)+"x"+strconv.Itoa(format.Height))
+	}
+	if format.Fps > 0 {
+		values.Set("fps", strconv.Itoa(format.Fps))
+	}
+	if format.ContentLength != "" {
+		values.Set("clen", format.ContentLength)
+	}
+	if format.LastModified != "" {
+		values.Set("lmt", format.LastModified)
+	}
+	if format.QualityLabel != "" {
+		values.Set("quality_label", format.QualityLabel)
+	}
+	if format.InitRange != nil {
+		values.Set("init", format.InitRange.Start+"-"+format.InitRange.End)
+	}
+	if format.IndexRange != nil {
+		values.Set("index", format.IndexRange.Start+"-"+format.IndexRange.End)
+	}
+	if format.ColorInfo != nil {
+		if format.ColorInfo.Primaries != "" {
+			values.Set("primaries", strings.ToLower(strings.TrimPrefix(format.ColorInfo.Primaries, "COLOR_PRIMARIES_")))
+		}
+		if format.ColorInfo.TransferCharacteristics != "" {
+			values.Set("eotf", strings.ToLower(strings.TrimPrefix(format.ColorInfo.TransferCharacteristics, "COLOR_TRANSFER_CHARACTERISTICS_")))
+		}
+	}
+	return values
+}
+
+// parseLikeDislikeButtons reads the counters from the like and
+// dislike buttons, leaving -1 when they are hidden
+func parseLikeDislikeButtons(video *Video) {
+	video.InfoJSON.LikeCount = -1
+	video.InfoJSON.DislikeCount = -1
+
+	reg := regexp.MustCompile("[^0-9]+")
+	walkRenderers(video.initialData, "toggleButtonRenderer", func(button map[string]interface{}) {
+		label := cast.ToString(lookup(button, "defaultText", "accessibility", "accessibilityData", "label"))
+		count := reg.ReplaceAllString(label, "")
+		if count == "" {
+			return
+		}
+
+		switch lookup(button, "defaultIcon", "iconType") {
+		case "LIKE":
+			video.InfoJSON.LikeCount = cast.ToFloat64(count)
+		case "DISLIKE":
+			video.InfoJSON.DislikeCount = cast.ToFloat64(count)
+		}
+	})
+}
+
+// parseMetadataRows reads the category and license rows
+// displayed under the description
+func parseMetadataRows(video *Video) {
+	walkRenderers(video.initialData, "metadataRowRenderer", func(row map[string]interface{}) {
+		var content string
+		contents, _ := row["contents"].([]interface{})
+		if len(contents) > 0 {
+			content = textOf(contents[0])
+		}
+
+		switch textOf(row["title"]) {
+		case "Category":
+			if video.InfoJSON.Category == "" {
+				video.InfoJSON.Category = content
+			}
+		case "License":
+			video.InfoJSON.License = content
+		}
+	})
+}
+
+// parseSuggestionsFromInitialData returns the IDs of the related videos
+func parseSuggestionsFromInitialData(video *Video) (videoIDs []string) {
+	walkRenderers(video.initialData, "compactVideoRenderer", func(renderer map[string]interface{}) {
+		if videoID, ok := renderer["videoId"].(string); ok {
+			videoIDs = append(videoIDs, videoID)
+		}
+	})
+	return videoIDs
+}
+
+// parsePlayerResponse fills the video from a decoded ytInitialPlayerResponse
+func parsePlayerResponse(video *Video, player *PlayerResponse) error {
+	status := player.PlayabilityStatus
+	if status.Status != "" && status.Status != "OK" {
+		return newUnavailableError(status.Status, status.Reason,
+			textOf(status.ErrorScreen.PlayerErrorMessageRenderer.Subreason))
+	}
+
+	details := player.VideoDetails
+	microformat := player.Microformat.PlayerMicroformatRenderer
+
+	video.InfoJSON.ID = video.ID
+	video.InfoJSON.Thumbnail = video.Thumbnail
+	video.InfoJSON.WebpageURL = "https://www.youtube.com/watch?v=" + video.ID
+
+	return extractFields(video, []fieldParser{
+		{fieldTitle, func() error {
+			title := strings.TrimSpace(details.Title)
+			if len(title) < 1 {
+				return errors.New("title of the video is empty, cancelation")
+			}
+			setTitle(video, title)
+			return nil
+		}},
+		{fieldDescription, func() error {
+			video.Description = details.ShortDescription
+			video.InfoJSON.Description = video.Description
+			return nil
+		}},
+		{fieldUploader, func() error {
+			video.InfoJSON.Uploader = details.Author
+			if video.InfoJSON.Uploader == "" {
+				video.InfoJSON.Uploader = microformat.OwnerChannelName
+			}
+			video.InfoJSON.UploaderID = details.ChannelID
+			if video.InfoJSON.UploaderID == "" {
+				video.InfoJSON.UploaderID = microformat.ExternalChannelID
+			}
+			if video.InfoJSON.UploaderID != "" {
+				video.InfoJSON.UploaderURL = "https://www.youtube.com/channel/" + video.InfoJSON.UploaderID
+			}
+			if video.InfoJSON.Uploader == "" || video.InfoJSON.UploaderID == "" {
+				return errors.New("error when parsing uploader informations, cancelation")
+			}
+			return nil
+		}},
+		{fieldUploadDate, func() error {
+			date := microformat.UploadDate
+			if date == "" {
+				date = microformat.PublishDate
+			}
+			// Dates may come with a time, e.g. 2020-01-01T00:00:00-08:00
+			if len(date) > 10 {
+				date = date[:10]
+			}
+			video.InfoJSON.UploadDate = strings.Replace(date, "-", "", -1)
+			if video.InfoJSON.UploadDate == "" {
+				return errors.New("error when parsing publication date, cancelation")
+			}
+			return nil
+		}},
+		{fieldViewCount, func() error {
+			video.InfoJSON.ViewCount = cast.ToFloat64(details.ViewCount)
+			return missingIf(details.ViewCount == "")
+		}},
+		{fieldAverageRating, func() error {
+			video.InfoJSON.AverageRating = details.AverageRating
+			return missingIf(details.AverageRating == 0)
+		}},
+		{fieldDuration, func() error {
+			video.InfoJSON.Duration = cast.ToFloat64(details.LengthSeconds)
+			return missingIf(details.LengthSeconds == "")
+		}},
+		{fieldTags, func() error {
+			video.InfoJSON.Tags = details.Keywords
+			return missingIf(len(details.Keywords) == 0)
+		}},
+		{fieldAgeLimit, func() error {
+			if microformat.IsFamilySafe != nil && !*microformat.IsFamilySafe {
+				video.InfoJSON.AgeLimit = 18
+			}
+			return missingIf(microformat.IsFamilySafe == nil)
+		}},
+		{fieldFormats, func() error {
+			// Muxed formats first, then the DASH ones, as youtube-dl lists them
+			formats := append([]StreamingFormat{}, player.StreamingData.Formats...)
+			formats = append(formats, player.StreamingData.AdaptiveFormats...)
+			for _, format := range formats {
+				video.RawFormats = append(video.RawFormats, streamingFormatToValues(format))
+			}
+			return parseFormats(video)
+		}},
+	})
+}
+
+// parseInitialData extracts the metadata from the ytInitialPlayerResponse
+// and ytInitialData JSON embedded in the page, returns errNoInitialData
+// if the page doesn't have them
+func parseInitialData(video *Video) error {
+	playerJSON := extractJSONObject(video.RawHTML, initialPlayerResponsePattern)
+	if playerJSON == nil {
+		return errNoInitialData
+	}
+
+	player := new(PlayerResponse)
+	err := json.Unmarshal(playerJSON, player)
+	if err != nil {
+		return err
+	}
+
+	// ytInitialData only brings the counters and the
+	// metadata rows, the page is still usable without it
+	dataJSON := extractJSONObject(video.RawHTML, initialDataPattern)
+	if dataJSON != nil {
+		err = json.Unmarshal(dataJSON, &video.initialData)
+		if err != nil {
+			return err
+		}
+	}
+
+	return parseResponses(video, player)
+}
+
+// parseResponses fills the video from the player response and
+// the ytInitialData (or /next response) stored in video.initialData
+func parseResponses(video *Video, player *PlayerResponse) error {
+	err := parsePlayerResponse(video, player)
+	if err != nil {
+		return err
+	}
+
+	return extractFields(video, []fieldParser{
+		{fieldLikes, func() error {
+			parseLikeDislikeButtons(video)
+			return missingIf(video.InfoJSON.LikeCount == -1)
+		}},
+		{fieldCategory, func() error {
+			video.InfoJSON.Category = player.Microformat.PlayerMicroformatRenderer.Category
+			parseMetadataRows(video)
+			if video.InfoJSON.Category == "" {
+				return errors.New("error when parsing category, cancelation")
+			}
+			return nil
+		}},
+		{fieldLicense, func() error {
+			return missingIf(video.InfoJSON.License == "")
+		}},
+	})
+}
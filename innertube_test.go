@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const innertubePlayerJSON = `{
+  "playabilityStatus": {"status": "OK"},
+  "streamingData": {"adaptiveFormats": [{
+    "itag": 140,
+    "url": "https://r1.googlevideo.com/videoplayback?itag=140",
+    "mimeType": "audio/mp4; codecs=\"mp4a.40.2\"",
+    "bitrate": 130000,
+    "contentLength": "3400000"
+  }]},
+  "videoDetails": {
+    "videoId": "MPBfVp0tB8E",
+    "title": "InnerTube video",
+    "lengthSeconds": "61",
+    "keywords": ["one", "two"],
+    "channelId": "UCabcdefghijklmnopqrstuv",
+    "shortDescription": "Description",
+    "viewCount": "42",
+    "author": "Uploader"
+  },
+  "microformat": {"playerMicroformatRenderer": {
+    "category": "Education",
+    "uploadDate": "2020-02-29"
+  }}
+}`
+
+const innertubeNextJSON = `{"contents": {"twoColumnWatchNextResults": {"results": {"results": {"contents": [
+  {"videoPrimaryInfoRenderer": {"videoActions": {"menuRenderer": {"topLevelButtons": [
+    {"toggleButtonRenderer": {"defaultIcon": {"iconType": "LIKE"},
+      "defaultText": {"accessibility": {"accessibilityData": {"label": "7 likes"}}}}}
+  ]}}}}
+]}}}}}`
+
+func TestParseInnerTube(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body innertubeRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid request body: %s", err)
+		}
+		if body.VideoID != "MPBfVp0tB8E" {
+			t.Errorf("videoId = %q", body.VideoID)
+		}
+		if body.Context.Client.ClientName != youtubeClientName ||
+			body.Context.Client.ClientVersion != youtubeClientVersion {
+			t.Errorf("client = %+v", body.Context.Client)
+		}
+		if r.Header.Get("X-YouTube-Client-Name") != youtubeClientNameID {
+			t.Errorf("X-YouTube-Client-Name = %q", r.Header.Get("X-YouTube-Client-Name"))
+		}
+
+		switch r.URL.Path {
+		case "/youtubei/v1/player":
+			w.Write([]byte(innertubePlayerJSON))
+		case "/youtubei/v1/next":
+			w.Write([]byte(innertubeNextJSON))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	defer func(URL string) { innertubeURL = URL }(innertubeURL)
+	innertubeURL = server.URL + "/youtubei/v1/"
+
+	video := new(Video)
+	video.ID = "MPBfVp0tB8E"
+	err := parseInnerTube(context.Background(), video)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := &video.InfoJSON
+	if info.Title != "InnerTube video" || video.Title != "InnerTube_video" {
+		t.Errorf("title = %q, %q", info.Title, video.Title)
+	}
+	if info.Uploader != "Uploader" || info.UploaderID != "UCabcdefghijklmnopqrstuv" {
+		t.Errorf("uploader = %q, %q", info.Uploader, info.UploaderID)
+	}
+	if info.UploadDate != "20200229" {
+		t.Errorf("upload date = %q", info.UploadDate)
+	}
+	if info.Category != "Education" || info.Duration != 61 || info.ViewCount != 42 {
+		t.Errorf("category = %q, duration = %v, views = %v", info.Category, info.Duration, info.ViewCount)
+	}
+	if info.LikeCount != 7 || info.DislikeCount != -1 {
+		t.Errorf("likes = %v, dislikes = %v", info.LikeCount, info.DislikeCount)
+	}
+	if len(info.Formats) != 1 || info.Formats[0].FormatID != "140" || info.Formats[0].Ext != "mp4" {
+		t.Errorf("formats = %+v", info.Formats)
+	}
+}
+
+func TestParseInnerTubeUnplayable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"playabilityStatus": {"status": "ERROR", "reason": "Video unavailable"}}`))
+	}))
+	defer server.Close()
+
+	defer func(URL string) { innertubeURL = URL }(innertubeURL)
+	innertubeURL = server.URL + "/youtubei/v1/"
+
+	video := new(Video)
+	video.ID = "MPBfVp0tB8E"
+	if err := parseInnerTube(context.Background(), video); err == nil {
+		t.Fatal("expected an error for an unplayable video")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var videoIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
+// Number of IDs read before pushing them to the queue
+const inputBatchSize = 1000
+
+// splitInputs separates the leading positional arguments (video IDs,
+// files or "-" for stdin) from the flags handled by argparse
+func splitInputs(args []string) (inputs []string, rest []string) {
+	if len(args) < 1 {
+		return nil, args
+	}
+
+	i := 1
+	for ; i < len(args); i++ {
+		if strings.HasPrefix(args[i], "-") && args[i] != "-" {
+			break
+		}
+		inputs = append(inputs, args[i])
+	}
+
+	rest = append([]string{args[0]}, args[i:]...)
+	return inputs, rest
+}
+
+// readIDs read a newline-delimited list of IDs, ignoring empty lines
+// and comments starting with #
+func readIDs(ctx context.Context, r io.Reader, q WorkQueue) error {
+	var batch []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if !videoIDPattern.MatchString(line) {
+			fmt.Fprintf(os.Stderr, "Ignoring invalid video ID: %s\n", line)
+			continue
+		}
+		batch = append(batch, line)
+
+		if len(batch) >= inputBatchSize {
+			err := q.Push(ctx, batch...)
+			if err != nil {
+				return err
+			}
+			batch = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if len(batch) > 0 {
+		return q.Push(ctx, batch...)
+	}
+	return nil
+}
+
+// feedInputs push every ID found in the inputs to the queue,
+// each input being either a video ID, a file of IDs or "-" for stdin
+func feedInputs(ctx context.Context, inputs []string, q WorkQueue) error {
+	for _, input := range inputs {
+		if input == "-" {
+			err := readIDs(ctx, os.Stdin, q)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := os.Stat(input); err == nil {
+			f, err := os.Open(input)
+			if err != nil {
+				return err
+			}
+			err = readIDs(ctx, f, q)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !videoIDPattern.MatchString(input) {
+			return fmt.Errorf("%s is neither a video ID nor a readable file", input)
+		}
+		err := q.Push(ctx, input)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitInputs(t *testing.T) {
+	tests := []struct {
+		args   []string
+		inputs []string
+		rest   []string
+	}{
+		{[]string{"YouTube-MA"}, nil, []string{"YouTube-MA"}},
+		{[]string{"YouTube-MA", "-j", "4"}, nil, []string{"YouTube-MA", "-j", "4"}},
+		{[]string{"YouTube-MA", "dQw4w9WgXcQ"}, []string{"dQw4w9WgXcQ"}, []string{"YouTube-MA"}},
+		{[]string{"YouTube-MA", "ids.txt", "-", "-j", "4"}, []string{"ids.txt", "-"}, []string{"YouTube-MA", "-j", "4"}},
+		{[]string{"YouTube-MA", "-", "--output", "out"}, []string{"-"}, []string{"YouTube-MA", "--output", "out"}},
+		// positional arguments after the flags belong to argparse
+		{[]string{"YouTube-MA", "-j", "4", "dQw4w9WgXcQ"}, nil, []string{"YouTube-MA", "-j", "4", "dQw4w9WgXcQ"}},
+	}
+
+	for _, test := range tests {
+		inputs, rest := splitInputs(test.args)
+		if !reflect.DeepEqual(inputs, test.inputs) || !reflect.DeepEqual(rest, test.rest) {
+			t.Errorf("splitInputs(%q) = %q, %q, want %q, %q", test.args, inputs, rest, test.inputs, test.rest)
+		}
+	}
+}
+
+func TestReadIDs(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"", nil},
+		{"dQw4w9WgXcQ\n", []string{"dQw4w9WgXcQ"}},
+		{"dQw4w9WgXcQ\njNQXAC9IVRw", []string{"dQw4w9WgXcQ", "jNQXAC9IVRw"}},
+		{"\n  \n\tdQw4w9WgXcQ  \r\n\n", []string{"dQw4w9WgXcQ"}},
+		{"# a comment\ndQw4w9WgXcQ\n  # an indented one\n", []string{"dQw4w9WgXcQ"}},
+		{"tooShort\ndQw4w9WgXcQ\nnot/an/ID!!\n", []string{"dQw4w9WgXcQ"}},
+		// duplicates are dropped by the queue
+		{"dQw4w9WgXcQ\ndQw4w9WgXcQ\n", []string{"dQw4w9WgXcQ"}},
+	}
+
+	for _, test := range tests {
+		q := newMemoryQueue()
+		err := readIDs(context.Background(), strings.NewReader(test.input), q)
+		if err != nil {
+			t.Errorf("readIDs(%q): %s", test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(q.pending, test.want) {
+			t.Errorf("readIDs(%q) pushed %q, want %q", test.input, q.pending, test.want)
+		}
+	}
+}
+
+func TestReadIDsBatches(t *testing.T) {
+	var lines []string
+	for i := 0; i < inputBatchSize+10; i++ {
+		lines = append(lines, fmt.Sprintf("batchID%04d", i))
+	}
+
+	q := newMemoryQueue()
+	err := readIDs(context.Background(), strings.NewReader(strings.Join(lines, "\n")), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(q.pending, lines) {
+		t.Errorf("pushed %d IDs, want %d", len(q.pending), len(lines))
+	}
+}
+
+func TestFeedInputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "youtube-ma-inputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// inputs are resolved relatively to the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	files := map[string]string{
+		"ids.txt": "# first batch\nfileID00001\n\nfileID00002\n",
+		// a file named like a video ID is read as a file
+		"fileID00003": "fileID00004\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stdin, err := ioutil.TempFile(dir, "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	stdin.WriteString("stdinID0001\n# comment\nstdinID0002\n")
+	stdin.Seek(0, 0)
+	defer func(f *os.File) { os.Stdin = f }(os.Stdin)
+	os.Stdin = stdin
+
+	tests := []struct {
+		inputs []string
+		want   []string
+		err    bool
+	}{
+		{[]string{"argID000001", "argID000002"}, []string{"argID000001", "argID000002"}, false},
+		{[]string{"ids.txt"}, []string{"fileID00001", "fileID00002"}, false},
+		{[]string{"fileID00003"}, []string{"fileID00004"}, false},
+		{[]string{"argID000001", "-", "ids.txt"}, []string{"argID000001", "stdinID0001", "stdinID0002", "fileID00001", "fileID00002"}, false},
+		{[]string{"argID000001", "missing.txt"}, []string{"argID000001"}, true},
+	}
+
+	for _, test := range tests {
+		stdin.Seek(0, 0)
+		q := newMemoryQueue()
+		err := feedInputs(context.Background(), test.inputs, q)
+		if (err != nil) != test.err {
+			t.Errorf("feedInputs(%q) error = %v, want error: %t", test.inputs, err, test.err)
+		}
+		if !reflect.DeepEqual(q.pending, test.want) {
+			t.Errorf("feedInputs(%q) pushed %q, want %q", test.inputs, q.pending, test.want)
+		}
+	}
+}
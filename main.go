@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	mapset "github.com/deckarep/golang-set"
+	"io"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
+
+	mapset "github.com/deckarep/golang-set"
 )
 
 var logFileName string
@@ -12,32 +18,84 @@ var logFileName string
 const inputChanSize = 512
 const outputChanSize = 32
 
-func archiveWorker(input, done chan string, inProgress mapset.Set) {
+// Time to wait when the queue has nothing for us
+var fetchRetryDelay = 10 * time.Second
+
+// Closed when a shutdown has been requested
+var stopping = make(chan struct{})
+
+func isStopping() bool {
+	select {
+	case <-stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// handleSignals stops fetching new IDs on the first SIGINT/SIGTERM
+// and cancels the videos in progress on the second one
+func handleSignals(cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	<-signals
+	fmt.Fprintln(os.Stderr, "Shutting down, waiting for the videos in progress, send the signal again to cancel them")
+	close(stopping)
+
+	<-signals
+	fmt.Fprintln(os.Stderr, "Canceling the videos in progress")
+	cancel()
+}
+
+func archiveWorker(ctx context.Context, input, done chan string, inProgress mapset.Set, wg *sync.WaitGroup) {
+	defer wg.Done()
+
 	for id := range input {
+		// Drain the IDs that won't be archived before exiting
+		if isStopping() {
+			continue
+		}
+
 		if inProgress.Contains(id) {
 			continue
 		}
 		inProgress.Add(id)
 
-		shouldMarkAsArchived := archiveID(id)
+		err := archiveID(ctx, id)
 
-		if shouldMarkAsArchived {
+		if err == nil {
 			done <- id
 		} else {
 			inProgress.Remove(id)
+
+			// Reported even if we got canceled, videos only unavailable
+			// to us are failures that may be tried again later
+			if unavailable, ok := err.(*UnavailableError); ok && unavailable.Permanent() {
+				err = queue.Tombstone(context.Background(), id, unavailable.Reason)
+			} else {
+				err = queue.Nack(context.Background(), id, err)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error while reporting failure of %s: %s\n", id, err.Error())
+			}
 		}
 	}
 }
 
-func markAsArchivedWorker(done chan string, inProgress mapset.Set) {
+func markAsArchivedWorker(done chan string, inProgress mapset.Set, finished chan struct{}) {
+	defer close(finished)
+
 	var buffer []string
 
 	for id := range done {
 		buffer = append(buffer, id)
 		inProgress.Remove(id)
 
-		if len(buffer) > outputChanSize {
-			err := markIDsArchived(buffer...)
+		// Batch the IDs done together, but don't keep any waiting, the
+		// queue holds back the next IDs and the end until they are acked
+		if len(buffer) > outputChanSize || len(done) == 0 {
+			err := queue.Ack(context.Background(), buffer...)
 			buffer = nil
 
 			if err != nil {
@@ -46,16 +104,125 @@ func markAsArchivedWorker(done chan string, inProgress mapset.Set) {
 			}
 		}
 	}
+
+	// Flush what's left once every worker is done
+	if len(buffer) > 0 {
+		err := queue.Ack(context.Background(), buffer...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while marking IDs as archived: %s\n", err.Error())
+		}
+	}
 }
 
 func main() {
+	// Audit an existing archive instead of archiving
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(runVerify(os.Args[1:]))
+	}
+
 	// Parse arguments
 	parseArgs(os.Args)
 
+	// Canceled to abort the videos in progress
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go handleSignals(cancel)
+
+	// Clean the videos left half-written by a previous run
+	err := cleanStaging()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while cleaning the staging directory: %s\n", err.Error())
+		os.Exit(1)
+	}
+
 	// Generate log file name based on current time
 	t := time.Now()
 	logFileName = t.Format("20060102150405") + ".log"
 
+	// Trust the CA bundle on top of the system certificates
+	if arguments.CABundle != "" {
+		tlsConfig, err = loadCABundle(arguments.CABundle)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while loading the CA bundle: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	directTripper = newDirectTransport()
+
+	// Keep the cookies across runs, and tell YouTube we
+	// don't want to see the consent page
+	if arguments.Cookies != "" {
+		err = cookieJar.loadCookies(arguments.Cookies)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while loading the cookies: %s\n", err.Error())
+			os.Exit(1)
+		}
+		defer func() {
+			err := cookieJar.saveCookies(arguments.Cookies)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error while saving the cookies: %s\n", err.Error())
+			}
+		}()
+	}
+	cookieJar.setConsent()
+
+	// Sign in the workers
+	sessions, err = loadSessions(arguments.Sessions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while loading the sessions: %s\n", err.Error())
+		os.Exit(1)
+	}
+	defer func() {
+		err := saveSessions()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while saving the sessions: %s\n", err.Error())
+		}
+	}()
+
+	// Spread the requests on the proxies
+	if len(arguments.Proxies) > 0 {
+		proxyPool, err = newProxyPool(arguments.Proxies, arguments.ProxyStrategy)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while setting up the proxies: %s\n", err.Error())
+			os.Exit(1)
+		}
+		stopProbing := make(chan struct{})
+		go proxyPool.probeProxies(stopProbing)
+		defer func() {
+			close(stopProbing)
+			proxyPool.printStats(os.Stderr)
+		}()
+	}
+
+	// Select where IDs come from
+	if arguments.Secret != "" {
+		queue = newEyeQueue(arguments.Secret)
+	} else if arguments.Queue != "" {
+		localQueue, err := openBoltQueue(arguments.Queue)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while opening the queue: %s\n", err.Error())
+			os.Exit(1)
+		}
+		queue = localQueue
+	} else {
+		queue = newMemoryQueue()
+	}
+	defer queue.Close()
+
+	err = feedInputs(ctx, arguments.Inputs, queue)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while reading inputs: %s\n", err.Error())
+		queue.Close()
+		os.Exit(1)
+	}
+
+	runWorkers(ctx)
+}
+
+// runWorkers archives the IDs of the queue until it is
+// exhausted or a shutdown is requested
+func runWorkers(ctx context.Context) {
 	inputIds := make(chan string, inputChanSize)
 	doneIds := make(chan string, outputChanSize)
 
@@ -63,17 +230,49 @@ func main() {
 	inProgress := mapset.NewSet()
 
 	// "Mark as archived" worker
-	go markAsArchivedWorker(doneIds, inProgress)
+	markFinished := make(chan struct{})
+	go markAsArchivedWorker(doneIds, inProgress, markFinished)
 
 	// "Archive" worker
+	var wg sync.WaitGroup
 	for i := 0; i < arguments.Concurrency; i++ {
-		go archiveWorker(inputIds, doneIds, inProgress)
+		wg.Add(1)
+		workerCtx := ctx
+		if len(sessions) > 0 {
+			workerCtx = withSession(ctx, sessions[i%len(sessions)])
+		}
+		go archiveWorker(workerCtx, inputIds, doneIds, inProgress, &wg)
 	}
 
 	// "Fetch IDs" worker
-	for {
-		for _, id := range getID(arguments.Secret, 0, inputChanSize*2) {
-			inputIds <- id
+fetch:
+	for !isStopping() {
+		IDs, err := queue.Fetch(ctx, inputChanSize*2)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while fetching IDs: %s\n", err.Error())
+		}
+		if len(IDs) == 0 {
+			select {
+			case <-stopping:
+			case <-time.After(fetchRetryDelay):
+			}
+			continue
+		}
+
+		for _, id := range IDs {
+			select {
+			case inputIds <- id:
+			case <-stopping:
+				break fetch
+			}
 		}
 	}
+
+	close(inputIds)
+	wg.Wait()
+	close(doneIds)
+	<-markFinished
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// runWorkersWithin runs the workers on q, failing if they don't return in time
+func runWorkersWithin(t *testing.T, q WorkQueue, timeout time.Duration) {
+	defer func(q WorkQueue, delay time.Duration) { queue, fetchRetryDelay = q, delay }(queue, fetchRetryDelay)
+	queue = q
+	fetchRetryDelay = 10 * time.Millisecond
+
+	finished := make(chan struct{})
+	go func() {
+		runWorkers(context.Background())
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(timeout):
+		t.Fatal("the workers didn't return once the queue was exhausted")
+	}
+}
+
+func TestRunWorkers(t *testing.T) {
+	defer useFixtures(t)()
+	arguments.Concurrency = 2
+
+	// fewer videos than the acks are batched by
+	IDs := []string{legacyFixtureID, modernFixtureID, "missingVid0"}
+
+	memory := newMemoryQueue()
+	memory.Push(context.Background(), IDs...)
+	runWorkersWithin(t, memory, 10*time.Second)
+	if !memory.done[legacyFixtureID] || !memory.done[modernFixtureID] {
+		t.Errorf("done = %v", memory.done)
+	}
+	if memory.unavailable["missingVid0"] != unavailableNotFound {
+		t.Errorf("unavailable = %v", memory.unavailable)
+	}
+
+	path, cleanup := tempBoltQueue(t)
+	defer cleanup()
+	bolt, err := openBoltQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+
+	// the archived videos are skipped, and acked all the same
+	bolt.Push(context.Background(), IDs...)
+	runWorkersWithin(t, bolt, 10*time.Second)
+	for ID, want := range map[string]string{legacyFixtureID: stateDone, modernFixtureID: stateDone, "missingVid0": stateUnavailable} {
+		if state := queueState(t, bolt, ID); state != want {
+			t.Errorf("state of %s = %q, want %q", ID, state, want)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// version of youtube-ma, set at build time by go-release.sh
+var version = "dev"
+
+// Extension of the manifest written with the files of each video
+const manifestExtension = ".manifest.json"
+
+// Artifact structure containing a file produced for a video
+type Artifact struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// Manifest structure containing every file of an archived video
+type Manifest struct {
+	ID          string     `json:"id"`
+	ToolVersion string     `json:"tool_version"`
+	ArchivedAt  time.Time  `json:"archived_at"`
+	Files       []Artifact `json:"files"`
+}
+
+// hashFile returns the size and the SHA-256 of a file
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeManifest hashes the staged files of the video and lists them
+// in its manifest, which is the last file written for the video
+func writeManifest(video *Video) error {
+	manifest := Manifest{
+		ID:          video.ID,
+		ToolVersion: version,
+		ArchivedAt:  time.Now().UTC(),
+	}
+
+	for i := range video.Files {
+		artifact := &video.Files[i]
+		size, sum, err := hashFile(video.StagingPath + artifact.Name)
+		if err != nil {
+			return err
+		}
+		artifact.Size = size
+		artifact.SHA256 = sum
+	}
+	manifest.Files = video.Files
+
+	JSON, err := JSONMarshalIndentNoEscapeHTML(&manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(video.StagingPath+video.FileName+manifestExtension, JSON, 0644)
+}
+
+// readManifest reads the manifest at path
+func readManifest(path string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := new(Manifest)
+	err = json.Unmarshal(data, manifest)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// findManifest returns the path of the manifest of the video, a directory
+// that belongs to the video holds a single one whatever its file name is
+func findManifest(video *Video) string {
+	if dirTemplateIsPerVideo() {
+		matches, _ := filepath.Glob(video.Path + "*" + manifestExtension)
+		if len(matches) == 1 {
+			return matches[0]
+		}
+	}
+	return video.Path + video.FileName + manifestExtension
+}
+
+// checkManifest makes sure every file listed in the manifest is in dir
+// with the right size, hashing them all is left to the verify command
+func checkManifest(dir string, manifest *Manifest) error {
+	if len(manifest.Files) == 0 {
+		return errors.New("empty manifest")
+	}
+
+	for _, artifact := range manifest.Files {
+		info, err := os.Stat(filepath.Join(dir, artifact.Name))
+		if err != nil {
+			return err
+		}
+		if info.Size() != artifact.Size {
+			return errors.New(artifact.Name + " doesn't have the size listed in the manifest")
+		}
+	}
+	return nil
+}
+
+// backfillManifest writes the manifest of a video archived before
+// manifests existed, listing the files found next to its info.json,
+// and returns its path
+func backfillManifest(video *Video) (string, error) {
+	info := video.Path + video.FileName + ".info.json"
+	if dirTemplateIsPerVideo() {
+		matches, _ := filepath.Glob(video.Path + "*.info.json")
+		if len(matches) == 1 {
+			info = matches[0]
+		}
+	}
+
+	data, err := ioutil.ReadFile(info)
+	if err != nil {
+		return "", err
+	}
+	var archived struct {
+		ID string `json:"id"`
+	}
+	err = json.Unmarshal(data, &archived)
+	if err != nil {
+		return "", err
+	}
+	if archived.ID != video.ID {
+		return "", errors.New(info + " is the info.json of " + archived.ID)
+	}
+
+	base := strings.TrimSuffix(filepath.Base(info), ".info.json")
+	files, err := ioutil.ReadDir(video.Path)
+	if err != nil {
+		return "", err
+	}
+
+	manifest := Manifest{
+		ID:          video.ID,
+		ToolVersion: version,
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasPrefix(file.Name(), base+".") {
+			continue
+		}
+		size, sum, err := hashFile(video.Path + file.Name())
+		if err != nil {
+			return "", err
+		}
+		manifest.Files = append(manifest.Files, Artifact{
+			Name:      file.Name(),
+			Size:      size,
+			SHA256:    sum,
+			FetchedAt: file.ModTime().UTC(),
+		})
+		if file.Name() == base+".info.json" {
+			manifest.ArchivedAt = file.ModTime().UTC()
+		}
+	}
+
+	JSON, err := JSONMarshalIndentNoEscapeHTML(&manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	// Through a temporary file, a half-written manifest would
+	// make the video look incomplete
+	path := video.Path + base + manifestExtension
+	return path, writeFileAtomic(path, JSON, 0644)
+}
+
+// clearManifest replaces the manifest at path by one listing no file,
+// so that the video is archived again instead of being backfilled
+func clearManifest(path string, ID string) error {
+	JSON, err := JSONMarshalIndentNoEscapeHTML(&Manifest{ID: ID, ToolVersion: version}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, JSON, 0644)
+}
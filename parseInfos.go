@@ -2,7 +2,7 @@ package main
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -19,6 +19,10 @@ import (
 	"github.com/spf13/cast"
 )
 
+// directTripper is used when no proxy is set, tests replace it
+// to serve recorded pages
+var directTripper = http.DefaultTransport
+
 type YtmaTripper struct {
 	Tripper http.RoundTripper
 }
@@ -27,36 +31,58 @@ func (t *YtmaTripper) RoundTrip(req *http.Request) (res *http.Response, err erro
 
 	req.Header.Add("Accept-Language", "en-US")
 	req.Header.Add("Host", "www.youtube.com")
-	req.Header.Add("X-YouTube-Client-Name", "1")
-	req.Header.Add("X-YouTube-Client-Version", "2.20170707")
+	req.Header.Add("X-YouTube-Client-Name", youtubeClientNameID)
+	req.Header.Add("X-YouTube-Client-Version", youtubeClientVersion)
 
 	return t.Tripper.RoundTrip(req)
 }
 
-func getHttpClient() *http.Client {
+// routeTripper sends the requests through the proxy pool or
+// directly, recording or replaying them with --record and --replay
+type routeTripper struct{}
 
-	var client *http.Client
+func (routeTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var tripper http.RoundTripper = directTripper
+	if proxyPool != nil {
+		tripper = &ProxyPoolTripper{Pool: proxyPool}
+	}
 
-	if arguments.Proxy != nil {
-		client = &http.Client{
-			Transport: &YtmaTripper{
-				Tripper: &http.Transport{
-					Proxy: http.ProxyURL(arguments.Proxy),
-					TLSClientConfig: &tls.Config{
-						InsecureSkipVerify: true,
-					},
-				},
-			},
-		}
-	} else {
-		client = &http.Client{
-			Transport: &YtmaTripper{
-				Tripper: http.DefaultTransport,
-			},
-		}
+	return cassetteTripper(&RateLimitTripper{
+		Limits:  rateLimits,
+		Tripper: tripper,
+	}).RoundTrip(req)
+}
+
+// cookieJar holds the cookies of every request, loaded
+// from and saved to the --cookies file
+var cookieJar = newCookieJar()
+
+// httpClient is shared by every request, so that the
+// connections to each host are reused across videos
+var httpClient = &http.Client{
+	Transport: &YtmaTripper{
+		Tripper: &ConsentTripper{Jar: cookieJar, Tripper: routeTripper{}},
+	},
+	Jar: cookieJar,
+}
+
+// getHttpClient returns the client of the session of the
+// worker, or the shared one when it has none
+func getHttpClient(ctx context.Context) *http.Client {
+	if session := sessionOf(ctx); session != nil {
+		return session.Client
 	}
+	return httpClient
+}
 
-	return client
+// httpGet request the URL, giving up as soon as the context is done
+func httpGet(ctx context.Context, URL string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return getHttpClient(ctx).Do(req.WithContext(ctx))
 }
 
 func parsePlayerArgs(video *Video, document *goquery.Document) error {
@@ -130,7 +156,7 @@ func parseLikeDislike(video *Video, document *goquery.Document) error {
 		video.InfoJSON.DislikeCount = cast.ToFloat64(strings.Replace(dislikeCount, ",", "", -1))
 	})
 
-	if video.InfoJSON.LikeCount == -1 || video.InfoJSON.LikeCount == -1 {
+	if video.InfoJSON.LikeCount == -1 || video.InfoJSON.DislikeCount == -1 {
 		return errors.New("error when parsing like/dislike counter, cancelation")
 	}
 	return nil
@@ -167,7 +193,7 @@ func parseViewCount(video *Video, document *goquery.Document) error {
 	})
 
 	if video.InfoJSON.ViewCount == -1 {
-		errors.New("error when parsing views, cancelation")
+		return errors.New("error when parsing views, cancelation")
 	}
 	return nil
 }
@@ -247,94 +273,110 @@ func parseVariousInfo(video *Video, document *goquery.Document) (err error) {
 	video.InfoJSON.Thumbnail = video.Thumbnail
 	video.InfoJSON.WebpageURL = "https://www.youtube.com/watch?v=" + video.ID
 
-	err = parseUploaderInfo(video, document)
-	if err != nil {
-		return err
-	}
-
-	err = parseLikeDislike(video, document)
-	if err != nil {
-		return err
-	}
-
-	err = parseDatePublished(video, document)
-	if err != nil {
-		return err
-	}
-
-	parseLicense(video)
+	return extractFields(video, []fieldParser{
+		{fieldUploader, func() error { return parseUploaderInfo(video, document) }},
+		{fieldLikes, func() error { return parseLikeDislike(video, document) }},
+		{fieldUploadDate, func() error { return parseDatePublished(video, document) }},
+		{fieldLicense, func() error {
+			parseLicense(video)
+			return missingIf(video.InfoJSON.License == "")
+		}},
+		{fieldViewCount, func() error { return parseViewCount(video, document) }},
+		{fieldAverageRating, func() error {
+			parseAverageRating(video)
+			return missingIf(video.InfoJSON.AverageRating == 0)
+		}},
+		{fieldFormats, func() error { return parseFormats(video) }},
+		{fieldTags, func() error {
+			parseTags(video, document)
+			return missingIf(len(video.InfoJSON.Tags) == 0)
+		}},
+		{fieldCategory, func() error { return parseCategory(video, document) }},
+		{fieldAgeLimit, func() error { return parseAgeLimit(video) }},
+		{fieldDuration, func() error {
+			parseDuration(video)
+			return missingIf(video.InfoJSON.Duration == 0)
+		}},
+	})
+}
 
-	err = parseViewCount(video, document)
-	if err != nil {
-		return err
+func parseTitle(video *Video, document *goquery.Document) error {
+	title := strings.TrimSpace(document.Find("#eow-title").Text())
+	if len(title) < 1 {
+		return errors.New("title of the video is empty, cancelation")
 	}
 
-	parseAverageRating(video)
+	setTitle(video, title)
+	return nil
+}
 
-	err = parseFormats(video)
-	if err != nil {
-		return err
-	}
+// setTitle stores the title and the version used in file names
+func setTitle(video *Video, title string) {
+	video.InfoJSON.Title = title
+	video.Title = escapeFileName(strings.Replace(title, " ", "_", -1))
+}
 
-	parseTags(video, document)
+// grabSuggestions push the related videos, document is nil
+// when the metadata didn't come from the watch page
+func grabSuggestions(ctx context.Context, video *Video, document *goquery.Document) error {
+	videoIDs := parseSuggestionsFromInitialData(video)
 
-	err = parseCategory(video, document)
-	if err != nil {
-		return err
+	if document != nil {
+		document.Find("span").Each(func(i int, s *goquery.Selection) {
+			if name, _ := s.Attr("class"); name == "yt-uix-simple-thumb-wrap yt-uix-simple-thumb-related" {
+				videoID, _ := s.Attr("data-vid")
+				videoIDs = append(videoIDs, videoID)
+			}
+		})
 	}
 
-	err = parseAgeLimit(video)
+	err := queue.Push(ctx, videoIDs...)
 	if err != nil {
 		return err
 	}
-
-	parseDuration(video)
-
 	return nil
 }
 
-func parseTitle(video *Video, document *goquery.Document) error {
-	title := strings.TrimSpace(document.Find("#eow-title").Text())
-	if len(title) < 1 {
-		return errors.New("title of the video is empty, cancelation")
-	}
-
-	video.InfoJSON.Title = title
-	video.Title = strings.Replace(title, " ", "_", -1)
-	video.Title = strings.Replace(video.Title, "/", "_", -1)
-	return nil
-}
-
-func grabSuggestions(document *goquery.Document) error {
-	var videoIDs []string
-
-	document.Find("span").Each(func(i int, s *goquery.Selection) {
-		if name, _ := s.Attr("class"); name == "yt-uix-simple-thumb-wrap yt-uix-simple-thumb-related" {
-			videoID, _ := s.Attr("data-vid")
-			videoIDs = append(videoIDs, videoID)
-		}
+// parseLegacyHTML scrapes the old desktop layout
+func parseLegacyHTML(video *Video, document *goquery.Document) error {
+	err := extractFields(video, []fieldParser{
+		{fieldTitle, func() error { return parseTitle(video, document) }},
+		{fieldDescription, func() error { return parseDescription(video, document) }},
 	})
-
-	err := pushIDs(videoIDs)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	// Without player arguments, the formats, rating and duration are missing
+	if parsePlayerArgs(video, document) != nil {
+		video.playerArgs = nil
+	}
+
+	return parseVariousInfo(video, document)
 }
 
-func parseHTML(video *Video) error {
+func parseHTML(ctx context.Context, video *Video) error {
 	// request video html page
-	html, err := getHttpClient().Get("https://youtube.com/watch?v=" + video.ID + "&gl=US&hl=en&has_verified=1&bpctr=9999999999")
+	html, err := httpGet(ctx, "https://youtube.com/watch?v="+video.ID+"&gl=US&hl=en&has_verified=1&bpctr=9999999999")
 	if err != nil {
 		return err
 	}
 	defer html.Body.Close()
 
 	// check status, exit if != 200
+	if html.StatusCode == 404 || html.StatusCode == 410 {
+		return &UnavailableError{Reason: unavailableNotFound, Status: html.Status}
+	}
 	if html.StatusCode != 200 {
-		return errors.New("status code != 200 when requesting the video page")
+		return &StatusError{URL: "the video page", StatusCode: html.StatusCode}
+	}
+	if html.Request != nil && isConsentPage(html.Request.URL) {
+		return errConsentRequired
 	}
 	body, err := ioutil.ReadAll(html.Body)
+	if err != nil {
+		return err
+	}
 
 	// store raw html in video struct
 	video.RawHTML = string(body)
@@ -345,29 +387,25 @@ func parseHTML(video *Video) error {
 		return err
 	}
 
-	err = parseTitle(video, document)
-	if err != nil {
-		return err
-	}
-
-	err = parseDescription(video, document)
-	if err != nil {
-		return err
-	}
-
-	err = parsePlayerArgs(video, document)
-	if err != nil {
-		return err
+	// Modern pages embed their metadata as JSON,
+	// the legacy layout is only used as a fallback
+	err = parseInitialData(video)
+	if err == errNoInitialData {
+		if unavailable := parseUnavailableMessage(document); unavailable != nil {
+			return unavailable
+		}
+		err = parseLegacyHTML(video, document)
 	}
-
-	err = parseVariousInfo(video, document)
 	if err != nil {
 		return err
 	}
 
-	err = grabSuggestions(document)
-	if err != nil {
-		return err
+	// Suggestions are only pushed to the youtube.the-eye.eu API
+	if arguments.Secret != "" {
+		err = grabSuggestions(ctx, video, document)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
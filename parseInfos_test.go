@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// legacyWithPlayerArgs returns the legacy fixture with its
+// ytplayer.config already parsed
+func legacyWithPlayerArgs(t *testing.T) *Video {
+	video, document := loadFixture(t, legacyFixtureID)
+	if err := parsePlayerArgs(video, document); err != nil {
+		t.Fatal(err)
+	}
+	return video
+}
+
+func TestParseTitle(t *testing.T) {
+	video, document := loadFixture(t, legacyFixtureID)
+	if err := parseTitle(video, document); err != nil {
+		t.Fatal(err)
+	}
+	if video.InfoJSON.Title != "Legacy fixture title" {
+		t.Errorf("title = %q", video.InfoJSON.Title)
+	}
+	if video.Title != "Legacy_fixture_title" {
+		t.Errorf("file title = %q", video.Title)
+	}
+}
+
+func TestParsePlayerArgs(t *testing.T) {
+	video := legacyWithPlayerArgs(t)
+	if video.playerArgs["length_seconds"] != "185" {
+		t.Errorf("length_seconds = %v", video.playerArgs["length_seconds"])
+	}
+
+	_, document := loadFixture(t, modernFixtureID)
+	if err := parsePlayerArgs(new(Video), document); err == nil {
+		t.Error("expected an error on a page without ytplayer.config")
+	}
+}
+
+func TestParseUploaderInfo(t *testing.T) {
+	video, document := loadFixture(t, legacyFixtureID)
+	if err := parseUploaderInfo(video, document); err != nil {
+		t.Fatal(err)
+	}
+	if video.InfoJSON.Uploader != "Legacy Uploader" ||
+		video.InfoJSON.UploaderID != "UClegacyuploader0000000" ||
+		video.InfoJSON.UploaderURL != "https://www.youtube.com/channel/UClegacyuploader0000000" {
+		t.Errorf("uploader = %q, %q, %q", video.InfoJSON.Uploader, video.InfoJSON.UploaderID, video.InfoJSON.UploaderURL)
+	}
+}
+
+func TestParseLikeDislike(t *testing.T) {
+	video, document := loadFixture(t, legacyFixtureID)
+	if err := parseLikeDislike(video, document); err != nil {
+		t.Fatal(err)
+	}
+	if video.InfoJSON.LikeCount != 1234 || video.InfoJSON.DislikeCount != 56 {
+		t.Errorf("likes = %v, dislikes = %v", video.InfoJSON.LikeCount, video.InfoJSON.DislikeCount)
+	}
+}
+
+func TestParseDatePublished(t *testing.T) {
+	video, document := loadFixture(t, legacyFixtureID)
+	if err := parseDatePublished(video, document); err != nil {
+		t.Fatal(err)
+	}
+	if video.InfoJSON.UploadDate != "20180725" {
+		t.Errorf("upload date = %q", video.InfoJSON.UploadDate)
+	}
+}
+
+func TestParseViewCount(t *testing.T) {
+	video, document := loadFixture(t, legacyFixtureID)
+	if err := parseViewCount(video, document); err != nil {
+		t.Fatal(err)
+	}
+	if video.InfoJSON.ViewCount != 98765 {
+		t.Errorf("views = %v", video.InfoJSON.ViewCount)
+	}
+}
+
+func TestParseAverageRatingAndDuration(t *testing.T) {
+	video := legacyWithPlayerArgs(t)
+	parseAverageRating(video)
+	parseDuration(video)
+	if video.InfoJSON.AverageRating != 4.8 || video.InfoJSON.Duration != 185 {
+		t.Errorf("rating = %v, duration = %v", video.InfoJSON.AverageRating, video.InfoJSON.Duration)
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	video, document := loadFixture(t, legacyFixtureID)
+	parseTags(video, document)
+	if !reflect.DeepEqual(video.InfoJSON.Tags, []string{"legacy", "fixture"}) {
+		t.Errorf("tags = %q", video.InfoJSON.Tags)
+	}
+}
+
+func TestParseCategoryLicenseAgeLimit(t *testing.T) {
+	video, document := loadFixture(t, legacyFixtureID)
+	if err := parseCategory(video, document); err != nil {
+		t.Fatal(err)
+	}
+	if err := parseAgeLimit(video); err != nil {
+		t.Fatal(err)
+	}
+	parseLicense(video)
+
+	if video.InfoJSON.Category != "Music" {
+		t.Errorf("category = %q", video.InfoJSON.Category)
+	}
+	if video.InfoJSON.License != "Creative Commons Attribution license (reuse allowed)" {
+		t.Errorf("license = %q", video.InfoJSON.License)
+	}
+	if video.InfoJSON.AgeLimit != 18 {
+		t.Errorf("age limit = %v", video.InfoJSON.AgeLimit)
+	}
+}
+
+func TestParseInitialData(t *testing.T) {
+	video, _ := loadFixture(t, modernFixtureID)
+	if err := parseInitialData(video); err != nil {
+		t.Fatal(err)
+	}
+
+	info := &video.InfoJSON
+	if info.Title != `A {test} "video" / with braces` {
+		t.Errorf("title = %q", info.Title)
+	}
+	if info.Description != "First line\nSecond line https://example.com" {
+		t.Errorf("description = %q", info.Description)
+	}
+	if info.LikeCount != 1234 || info.DislikeCount != 56 {
+		t.Errorf("likes = %v, dislikes = %v", info.LikeCount, info.DislikeCount)
+	}
+	if info.Category != "Music" || info.License != "Creative Commons Attribution license (reuse allowed)" {
+		t.Errorf("category = %q, license = %q", info.Category, info.License)
+	}
+	// the muxed format, then the adaptive ones
+	if len(info.Formats) != 3 || info.Formats[0].FormatID != "18" || info.Formats[0].Format != `18 - video/mp4; codecs="avc1.42001E, mp4a.40.2"` {
+		t.Errorf("formats = %+v", info.Formats)
+	}
+
+	suggestions := parseSuggestionsFromInitialData(video)
+	if !reflect.DeepEqual(suggestions, []string{"dQw4w9WgXcQ", "9bZkp7q19f0"}) {
+		t.Errorf("suggestions = %q", suggestions)
+	}
+
+	legacy, _ := loadFixture(t, legacyFixtureID)
+	if err := parseInitialData(legacy); err != errNoInitialData {
+		t.Errorf("legacy page error = %v", err)
+	}
+}
+
+func TestParseHTML(t *testing.T) {
+	defer useFixtures(t)()
+
+	for _, ID := range []string{legacyFixtureID, modernFixtureID} {
+		video := new(Video)
+		video.ID = ID
+		video.playerArgs = make(map[string]interface{})
+		if err := parseHTML(context.Background(), video); err != nil {
+			t.Errorf("%s: %s", ID, err)
+			continue
+		}
+		if video.InfoJSON.ID != ID || video.InfoJSON.UploadDate == "" || len(video.InfoJSON.Formats) == 0 {
+			t.Errorf("%s: incomplete info %+v", ID, &video.InfoJSON)
+		}
+	}
+
+	video := new(Video)
+	video.ID = "missingVid0"
+	if err := parseHTML(context.Background(), video); err == nil {
+		t.Error("expected an error on a 404 page")
+	}
+}
+
+func TestParseLegacyHTMLPartialSuccess(t *testing.T) {
+	defer useFixtures(t)()
+
+	// Hide the like and dislike counters
+	video, _ := loadFixture(t, legacyFixtureID)
+	video.RawHTML = strings.Replace(video.RawHTML, "like-button-renderer-", "hidden-", -1)
+	document, err := goquery.NewDocumentFromReader(bytes.NewReader([]byte(video.RawHTML)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := parseLegacyHTML(video, document); err != nil {
+		t.Fatal(err)
+	}
+	if video.FieldStatus[fieldLikes] == fieldOK || video.FieldStatus[fieldTitle] != fieldOK {
+		t.Errorf("status = %v", video.FieldStatus)
+	}
+	if video.InfoJSON.Category != "Music" {
+		t.Errorf("category = %q", video.InfoJSON.Category)
+	}
+
+	arguments.RequiredFields[fieldLikes] = true
+	video.FieldStatus = nil
+	if err := parseLegacyHTML(video, document); err == nil {
+		t.Error("expected an error when likes are required")
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Strategies to pick the proxy of each request
+const (
+	proxyRoundRobin  = "round-robin"
+	proxyLeastFailed = "least-failed"
+)
+
+// When a proxy is ejected from the pool, and for how long
+const (
+	proxyMaxFailures   = 3
+	proxyEjectDelay    = time.Minute
+	proxyMaxEjectDelay = 30 * time.Minute
+	proxyProbeInterval = 15 * time.Second
+)
+
+// proxyProbeURL is requested through an ejected proxy to
+// know if it works again, tests replace it
+var proxyProbeURL = "https://www.youtube.com/generate_204"
+
+var errNoHealthyProxy = errors.New("every proxy is ejected, cancelation")
+
+// Proxy structure containing a proxy of the pool and its stats
+type Proxy struct {
+	URL         *url.URL
+	Successes   int64
+	Failures    int64
+	LastFailure time.Time
+	Ejections   int
+	// the proxy isn't used until then, and probed afterwards
+	EjectedUntil time.Time
+
+	transport           http.RoundTripper
+	consecutiveFailures int
+}
+
+// ProxyPool structure containing the proxies requests are spread on
+type ProxyPool struct {
+	lock     sync.Mutex
+	Proxies  []*Proxy
+	Strategy string
+	next     int
+}
+
+var proxyPool *ProxyPool
+
+// parseProxy reads a proxy URL, host:port meaning an HTTP proxy
+func parseProxy(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, errors.New("unsupported proxy scheme " + u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("no host in proxy " + raw)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(proxyPort(u)))
+	}
+	return u, nil
+}
+
+// readProxies reads a list of proxies, one per line, ignoring
+// empty lines and comments starting with #
+func readProxies(r io.Reader) ([]*url.URL, error) {
+	var proxies []*url.URL
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		u, err := parseProxy(line)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, u)
+	}
+	return proxies, scanner.Err()
+}
+
+// proxyName is how the proxy is displayed, without its credentials
+func proxyName(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+func newProxyPool(proxies []*url.URL, strategy string) (*ProxyPool, error) {
+	pool := &ProxyPool{Strategy: strategy}
+	for _, u := range proxies {
+		transport, err := newProxyTransport(u)
+		if err != nil {
+			return nil, err
+		}
+		pool.Proxies = append(pool.Proxies, &Proxy{URL: u, transport: transport})
+	}
+	return pool, nil
+}
+
+// pick returns the proxy to use for the next request
+func (p *ProxyPool) pick() (*Proxy, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var picked *Proxy
+	for i := range p.Proxies {
+		proxy := p.Proxies[(p.next+i)%len(p.Proxies)]
+		if !proxy.EjectedUntil.IsZero() {
+			continue
+		}
+
+		if p.Strategy != proxyLeastFailed {
+			picked = proxy
+			break
+		}
+		if picked == nil || proxy.LastFailure.Before(picked.LastFailure) {
+			picked = proxy
+		}
+	}
+	if picked == nil {
+		return nil, errNoHealthyProxy
+	}
+
+	p.next++
+	return picked, nil
+}
+
+// report records the outcome of a request through the proxy,
+// ejecting it after too many failures in a row
+func (p *ProxyPool) report(proxy *Proxy, failed bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !failed {
+		proxy.Successes++
+		proxy.consecutiveFailures = 0
+		return
+	}
+
+	proxy.Failures++
+	proxy.LastFailure = time.Now()
+	proxy.consecutiveFailures++
+	if proxy.consecutiveFailures >= proxyMaxFailures && proxy.EjectedUntil.IsZero() {
+		p.eject(proxy)
+	}
+}
+
+// eject stops using the proxy, for longer every time it's ejected
+func (p *ProxyPool) eject(proxy *Proxy) {
+	delay := proxyEjectDelay
+	for i := 0; i < proxy.Ejections && delay < proxyMaxEjectDelay; i++ {
+		delay *= 2
+	}
+	if delay > proxyMaxEjectDelay {
+		delay = proxyMaxEjectDelay
+	}
+
+	proxy.Ejections++
+	proxy.EjectedUntil = time.Now().Add(delay)
+	fmt.Fprintf(os.Stderr, "Proxy %s ejected for %s after %d failures\n", proxyName(proxy.URL), delay, proxy.consecutiveFailures)
+}
+
+// probe tries the ejected proxies whose delay is over, putting back
+// in the pool those that work and ejecting the others again
+func (p *ProxyPool) probe() {
+	p.lock.Lock()
+	var due []*Proxy
+	now := time.Now()
+	for _, proxy := range p.Proxies {
+		if !proxy.EjectedUntil.IsZero() && !proxy.EjectedUntil.After(now) {
+			due = append(due, proxy)
+		}
+	}
+	p.lock.Unlock()
+
+	for _, proxy := range due {
+		client := &http.Client{Transport: proxy.transport, Timeout: proxyProbeInterval}
+		res, err := client.Get(proxyProbeURL)
+		if err == nil {
+			res.Body.Close()
+		}
+
+		p.lock.Lock()
+		if err == nil && res.StatusCode < 400 {
+			proxy.EjectedUntil = time.Time{}
+			proxy.consecutiveFailures = 0
+			fmt.Fprintf(os.Stderr, "Proxy %s is back in the pool\n", proxyName(proxy.URL))
+		} else {
+			p.eject(proxy)
+		}
+		p.lock.Unlock()
+	}
+}
+
+// probeProxies probes the ejected proxies until stop is closed
+func (p *ProxyPool) probeProxies(stop chan struct{}) {
+	ticker := time.NewTicker(proxyProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.probe()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// printStats writes the successes and failures of every proxy
+func (p *ProxyPool) printStats(w io.Writer) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, proxy := range p.Proxies {
+		state := "ok"
+		if !proxy.EjectedUntil.IsZero() {
+			state = "ejected"
+		}
+		fmt.Fprintf(w, "Proxy %s: %d successes, %d failures, %d ejections, %s\n",
+			proxyName(proxy.URL), proxy.Successes, proxy.Failures, proxy.Ejections, state)
+	}
+}
+
+// ProxyPoolTripper sends each request through a proxy of the pool
+type ProxyPoolTripper struct {
+	Pool *ProxyPool
+}
+
+func (t *ProxyPoolTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	proxy, err := t.Pool.pick()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := proxy.transport.RoundTrip(req)
+
+	// Not the fault of the proxy if we gave up on the request
+	if err != nil && req.Context().Err() != nil {
+		return nil, err
+	}
+
+	// A proxy that gets rate limited or sent to a
+	// captcha is as useless as one that is down
+	failed := err != nil ||
+		res.StatusCode == http.StatusTooManyRequests ||
+		res.StatusCode == http.StatusProxyAuthRequired ||
+		isInterstitial(req, res)
+	t.Pool.report(proxy, failed)
+	return res, err
+}
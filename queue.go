@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// WorkQueue is the source of the video IDs to archive
+type WorkQueue interface {
+	// Fetch returns up to limit IDs to archive, or io.EOF
+	// once there is nothing left to archive
+	Fetch(ctx context.Context, limit int) ([]string, error)
+	// Ack marks IDs as archived
+	Ack(ctx context.Context, IDs ...string) error
+	// Nack reports that an ID couldn't be archived
+	Nack(ctx context.Context, ID string, reason error) error
+	// Tombstone reports that an ID will never be archived, it
+	// must not be served again
+	Tombstone(ctx context.Context, ID string, reason string) error
+	// Push adds new IDs to archive
+	Push(ctx context.Context, IDs ...string) error
+	// Requeue puts IDs back to archive, even those already archived
+	Requeue(ctx context.Context, IDs ...string) error
+	// Close releases the resources held by the queue
+	Close() error
+}
+
+var queue WorkQueue
+
+// memoryQueue is a WorkQueue living in memory, used for local inputs
+type memoryQueue struct {
+	lock       sync.Mutex
+	pending    []string
+	seen       map[string]bool
+	inProgress map[string]bool
+	done       map[string]bool
+	failed     map[string]error
+	// when each video waiting for a retry can be tried again
+	retrying map[string]time.Time
+	history  map[string]*FailureHistory
+	// reason why each unavailable video can't be archived
+	unavailable map[string]string
+}
+
+func newMemoryQueue() *memoryQueue {
+	return &memoryQueue{
+		seen:        make(map[string]bool),
+		inProgress:  make(map[string]bool),
+		done:        make(map[string]bool),
+		failed:      make(map[string]error),
+		retrying:    make(map[string]time.Time),
+		history:     make(map[string]*FailureHistory),
+		unavailable: make(map[string]string),
+	}
+}
+
+// Fetch returns no ID while videos are in progress or waiting
+// for a retry, and io.EOF once everything is over
+func (q *memoryQueue) Fetch(ctx context.Context, limit int) ([]string, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	now := time.Now()
+	for ID, retryAt := range q.retrying {
+		if !retryAt.After(now) {
+			delete(q.retrying, ID)
+			q.pending = append(q.pending, ID)
+		}
+	}
+
+	if len(q.pending) == 0 {
+		if len(q.inProgress) > 0 || len(q.retrying) > 0 {
+			return nil, nil
+		}
+		return nil, io.EOF
+	}
+
+	if limit > len(q.pending) {
+		limit = len(q.pending)
+	}
+	IDs := q.pending[:limit]
+	q.pending = q.pending[limit:]
+	for _, ID := range IDs {
+		q.inProgress[ID] = true
+	}
+	return IDs, nil
+}
+
+func (q *memoryQueue) Ack(ctx context.Context, IDs ...string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for _, ID := range IDs {
+		q.done[ID] = true
+		delete(q.inProgress, ID)
+		delete(q.failed, ID)
+	}
+	return nil
+}
+
+func (q *memoryQueue) Nack(ctx context.Context, ID string, reason error) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	delete(q.inProgress, ID)
+	if reason == nil {
+		q.failed[ID] = reason
+		return nil
+	}
+
+	history := q.history[ID]
+	if history == nil {
+		history = new(FailureHistory)
+		q.history[ID] = history
+	}
+	if retryAt, ok := history.record(reason, time.Now()); ok {
+		q.retrying[ID] = retryAt
+		return nil
+	}
+	q.failed[ID] = reason
+	return nil
+}
+
+func (q *memoryQueue) Tombstone(ctx context.Context, ID string, reason string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	delete(q.inProgress, ID)
+	delete(q.failed, ID)
+	q.unavailable[ID] = reason
+	return nil
+}
+
+func (q *memoryQueue) Push(ctx context.Context, IDs ...string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for _, ID := range IDs {
+		if q.seen[ID] {
+			continue
+		}
+		q.seen[ID] = true
+		q.pending = append(q.pending, ID)
+	}
+	return nil
+}
+
+func (q *memoryQueue) Requeue(ctx context.Context, IDs ...string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for _, ID := range IDs {
+		if q.inProgress[ID] || q.isPending(ID) {
+			continue
+		}
+		delete(q.done, ID)
+		delete(q.failed, ID)
+		delete(q.retrying, ID)
+		delete(q.unavailable, ID)
+		if history := q.history[ID]; history != nil {
+			history.reset()
+		}
+		q.seen[ID] = true
+		q.pending = append(q.pending, ID)
+	}
+	return nil
+}
+
+// isPending tells whether ID is waiting in q.pending, the caller must hold the lock
+func (q *memoryQueue) isPending(ID string) bool {
+	if !q.seen[ID] || q.inProgress[ID] || q.done[ID] {
+		return false
+	}
+	if _, failed := q.failed[ID]; failed {
+		return false
+	}
+	if _, retrying := q.retrying[ID]; retrying {
+		return false
+	}
+	_, unavailable := q.unavailable[ID]
+	return !unavailable
+}
+
+func (q *memoryQueue) Close() error {
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestMemoryQueueRequeue(t *testing.T) {
+	ctx := context.Background()
+	q := newMemoryQueue()
+	q.Push(ctx, "a", "b", "c")
+
+	// pending IDs aren't queued twice
+	q.Requeue(ctx, "a", "a")
+	IDs, err := q.Fetch(ctx, 2)
+	if err != nil || !reflect.DeepEqual(IDs, []string{"a", "b"}) {
+		t.Fatalf("Fetch() = %q, %v", IDs, err)
+	}
+
+	// neither are IDs in progress
+	q.Requeue(ctx, "a")
+	q.Ack(ctx, "a", "b")
+
+	// archived IDs are queued again, once
+	q.Requeue(ctx, "b", "b")
+	q.Requeue(ctx, "b")
+	IDs, err = q.Fetch(ctx, 10)
+	if err != nil || !reflect.DeepEqual(IDs, []string{"c", "b"}) {
+		t.Fatalf("Fetch() = %q, %v", IDs, err)
+	}
+	q.Ack(ctx, IDs...)
+
+	if _, err := q.Fetch(ctx, 10); err != io.EOF {
+		t.Errorf("Fetch() returned %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// How the rate of a host is lowered when it pushes back, and how
+// long it has to behave before the rate goes up again
+const (
+	slowDownFactor = 0.5
+	speedUpFactor  = 1.25
+	minHostRate    = 0.1
+	recoveryDelay  = time.Minute
+	// pause when a 429 doesn't tell how long to wait
+	defaultRetryAfter = 30 * time.Second
+)
+
+// hostLimiter is the token bucket of a single host
+type hostLimiter struct {
+	lock        sync.Mutex
+	limiter     *rate.Limiter
+	base        rate.Limit
+	pausedUntil time.Time
+	// when it last pushed back, and when its rate was last cut
+	slowedAt time.Time
+	cutAt    time.Time
+}
+
+// RateLimits structure containing the token buckets of every host, and
+// the one shared by all the requests whatever their host is
+type RateLimits struct {
+	lock     sync.Mutex
+	Global   float64
+	Default  float64
+	PerHost  map[string]float64
+	global   *rate.Limiter
+	limiters map[string]*hostLimiter
+}
+
+var rateLimits = &RateLimits{}
+
+func newRateLimits(globalRate float64, defaultRate float64, perHost map[string]float64) *RateLimits {
+	l := &RateLimits{
+		Global:   globalRate,
+		Default:  defaultRate,
+		PerHost:  perHost,
+		limiters: make(map[string]*hostLimiter),
+	}
+	if globalRate > 0 {
+		l.global = rate.NewLimiter(rate.Limit(globalRate), burstOf(globalRate))
+	}
+	return l
+}
+
+// burstOf returns the burst of a bucket, a second worth of requests
+func burstOf(r float64) int {
+	if r < 1 {
+		return 1
+	}
+	return int(r)
+}
+
+// parseHostRates reads the comma-separated host=rate list of --host-rate
+func parseHostRates(list string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	for _, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("invalid host rate " + pair + ", expected host=requests per second")
+		}
+		r, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || r < 0 {
+			return nil, errors.New("invalid rate for " + parts[0] + ": " + parts[1])
+		}
+		rates[strings.ToLower(parts[0])] = r
+	}
+	return rates, nil
+}
+
+// host returns the token bucket of the host, nil if it isn't limited
+func (l *RateLimits) host(name string) *hostLimiter {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	name = strings.ToLower(name)
+	if h, ok := l.limiters[name]; ok {
+		return h
+	}
+
+	r, ok := l.PerHost[name]
+	if !ok {
+		r = l.Default
+	}
+
+	var h *hostLimiter
+	if r > 0 {
+		h = &hostLimiter{limiter: rate.NewLimiter(rate.Limit(r), burstOf(r)), base: rate.Limit(r)}
+	}
+	if l.limiters == nil {
+		l.limiters = make(map[string]*hostLimiter)
+	}
+	l.limiters[name] = h
+	return h
+}
+
+// wait blocks until the host can be requested again, the pause
+// may be extended by the answers of other requests in the meantime
+func (h *hostLimiter) wait(req *http.Request) error {
+	ctx := req.Context()
+
+	for {
+		h.lock.Lock()
+		pause := time.Until(h.pausedUntil)
+		h.lock.Unlock()
+
+		if pause <= 0 {
+			break
+		}
+		select {
+		case <-time.After(pause):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return h.limiter.Wait(ctx)
+}
+
+// slowDown lowers the rate of the host, and stops requesting
+// it at all for the given duration
+func (h *hostLimiter) slowDown(pause time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	now := time.Now()
+	if until := now.Add(pause); until.After(h.pausedUntil) {
+		h.pausedUntil = until
+	}
+	h.slowedAt = now
+
+	// The requests in flight get the same answer, the rate
+	// is only cut once for all of them
+	if now.Sub(h.cutAt) < recoveryDelay {
+		return
+	}
+
+	limit := h.limiter.Limit() * slowDownFactor
+	if limit < minHostRate {
+		limit = minHostRate
+	}
+	h.limiter.SetLimit(limit)
+	h.cutAt = now
+}
+
+// speedUp brings the rate back toward its configured value
+// once the host stopped pushing back for a while
+func (h *hostLimiter) speedUp() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	limit := h.limiter.Limit()
+	if limit >= h.base || time.Since(h.slowedAt) < recoveryDelay {
+		return
+	}
+
+	limit *= speedUpFactor
+	if limit > h.base {
+		limit = h.base
+	}
+	h.limiter.SetLimit(limit)
+	h.slowedAt = time.Now()
+}
+
+// parseRetryAfter reads a Retry-After header, in seconds or as a date
+func parseRetryAfter(header string) (time.Duration, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return time.Until(date), true
+	}
+	return 0, false
+}
+
+// isInterstitial tells if YouTube sent us to a captcha or to
+// the consent page instead of what we asked
+func isInterstitial(req *http.Request, res *http.Response) bool {
+	URLs := []string{req.URL.String()}
+	if location := res.Header.Get("Location"); location != "" {
+		URLs = append(URLs, location)
+	}
+	for _, URL := range URLs {
+		if strings.Contains(URL, "consent.youtube.com") ||
+			strings.Contains(URL, "consent.google.com") ||
+			strings.Contains(URL, "google.com/sorry") ||
+			strings.Contains(URL, "/das_captcha") {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimitTripper waits for the global token bucket, then for the one
+// of the host before each request, and slows down when the host pushes back
+type RateLimitTripper struct {
+	Limits  *RateLimits
+	Tripper http.RoundTripper
+}
+
+func (t *RateLimitTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Limits.global != nil {
+		err := t.Limits.global.Wait(req.Context())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	h := t.Limits.host(req.URL.Hostname())
+	if h == nil {
+		return t.Tripper.RoundTrip(req)
+	}
+
+	err := h.wait(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := t.Tripper.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	pause, hasRetryAfter := parseRetryAfter(res.Header.Get("Retry-After"))
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests:
+		if !hasRetryAfter {
+			pause = defaultRetryAfter
+		}
+		h.slowDown(pause)
+	case res.StatusCode == http.StatusServiceUnavailable && hasRetryAfter:
+		h.slowDown(pause)
+	case isInterstitial(req, res) && !retriedForConsent(req, res):
+		h.slowDown(defaultRetryAfter)
+	default:
+		h.speedUp()
+	}
+	return res, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// statusTripper answers every request with the same status and headers
+type statusTripper struct {
+	StatusCode int
+	Header     http.Header
+}
+
+func (t statusTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: t.StatusCode,
+		Header:     t.Header,
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		Request:    req,
+	}, nil
+}
+
+func TestParseHostRates(t *testing.T) {
+	rates, err := parseHostRates("i3.ytimg.com=20, WWW.youtube.com=0.5")
+	if err != nil || rates["i3.ytimg.com"] != 20 || rates["www.youtube.com"] != 0.5 {
+		t.Errorf("parseHostRates() = %v, %v", rates, err)
+	}
+	for _, invalid := range []string{"youtube.com", "youtube.com=fast", "youtube.com=-1"} {
+		if _, err := parseHostRates(invalid); err == nil {
+			t.Errorf("parseHostRates(%q) accepted", invalid)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if pause, ok := parseRetryAfter("120"); !ok || pause != 2*time.Minute {
+		t.Errorf("parseRetryAfter(120) = %s, %v", pause, ok)
+	}
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if pause, ok := parseRetryAfter(date); !ok || pause < 59*time.Minute || pause > time.Hour {
+		t.Errorf("parseRetryAfter(%s) = %s, %v", date, pause, ok)
+	}
+	if _, ok := parseRetryAfter("soon"); ok {
+		t.Error("invalid Retry-After accepted")
+	}
+}
+
+func TestRateLimitTripper(t *testing.T) {
+	limits := newRateLimits(0, 4, map[string]float64{"i3.ytimg.com": 0})
+	tripper := &RateLimitTripper{Limits: limits}
+
+	// hosts without limit are left alone
+	tripper.Tripper = statusTripper{StatusCode: http.StatusOK}
+	req, _ := http.NewRequest(http.MethodGet, "http://i3.ytimg.com/vi/MPBfVp0tB8E/maxresdefault.jpg", nil)
+	tripper.RoundTrip(req)
+	if limits.host("i3.ytimg.com") != nil {
+		t.Error("unlimited host got a limiter")
+	}
+
+	// a 429 pauses the host for Retry-After and halves its rate
+	tripper.Tripper = statusTripper{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"60"}}}
+	req, _ = http.NewRequest(http.MethodGet, "https://www.youtube.com/watch?v=MPBfVp0tB8E", nil)
+	if _, err := tripper.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	h := limits.host("www.youtube.com")
+	if h.limiter.Limit() != 2 || time.Until(h.pausedUntil) < 59*time.Second {
+		t.Errorf("limit = %v, paused for %s", h.limiter.Limit(), time.Until(h.pausedUntil))
+	}
+
+	// the other requests in flight getting a 429 don't cut it again
+	for i := 0; i < 3; i++ {
+		h.slowDown(time.Minute)
+	}
+	if h.limiter.Limit() != 2 {
+		t.Errorf("limit = %v after concurrent 429s", h.limiter.Limit())
+	}
+
+	// the consent page slows down too
+	tripper.Tripper = statusTripper{StatusCode: http.StatusFound, Header: http.Header{"Location": {"https://consent.youtube.com/m?continue=https://www.youtube.com/watch"}}}
+	h.pausedUntil = time.Time{}
+	h.cutAt = time.Now().Add(-recoveryDelay)
+	tripper.RoundTrip(req)
+	if h.limiter.Limit() != 1 {
+		t.Errorf("limit = %v after the consent page", h.limiter.Limit())
+	}
+
+	// the rate goes back up once the host behaves for a while
+	tripper.Tripper = statusTripper{StatusCode: http.StatusOK}
+	h.pausedUntil = time.Time{}
+	h.slowedAt = time.Now().Add(-recoveryDelay)
+	tripper.RoundTrip(req)
+	if h.limiter.Limit() != rate.Limit(1*speedUpFactor) {
+		t.Errorf("limit = %v after recovering", h.limiter.Limit())
+	}
+}
+
+func TestGlobalRateLimit(t *testing.T) {
+	limits := newRateLimits(10, 0, nil)
+	tripper := &RateLimitTripper{Limits: limits, Tripper: statusTripper{StatusCode: http.StatusOK}}
+
+	// the burst is a second worth of requests, whatever their host is,
+	// the next ones wait for the bucket
+	start := time.Now()
+	for i := 0; i < 12; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "https://host"+string(rune('a'+i))+".example/", nil)
+		if _, err := tripper.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("12 requests at 10 per second took %s", elapsed)
+	}
+	if limits.host("hosta.example") != nil {
+		t.Error("host without limit got a limiter")
+	}
+}
+
+func TestHostLimiterWaitExtended(t *testing.T) {
+	limits := newRateLimits(0, 100, nil)
+	h := limits.host("www.youtube.com")
+	h.slowDown(50 * time.Millisecond)
+
+	// another request gets a 429 while this one waits
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		h.slowDown(200 * time.Millisecond)
+	}()
+
+	start := time.Now()
+	req, _ := http.NewRequest(http.MethodGet, "https://www.youtube.com/", nil)
+	if err := h.wait(req); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("waited %s, the extended pause wasn't honored", elapsed)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Extension of the files keeping what changed at each refresh
+const changesExtension = ".changes.json"
+
+// Fields of the info.json changing at every fetch without the video
+// being edited, such as the expiring URLs of the formats
+var refreshIgnoredFields = map[string]bool{
+	fieldFormats: true,
+}
+
+// FieldChange structure containing the values of a field before and after a refresh
+type FieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// Changes structure containing the fields that changed since the previous capture
+type Changes struct {
+	ID                string                 `json:"id"`
+	PreviousFetchedAt time.Time              `json:"previous_fetched_at"`
+	FetchedAt         time.Time              `json:"fetched_at"`
+	Fields            map[string]FieldChange `json:"fields"`
+}
+
+// loadArchived reads the manifest and the info.json of an archived video,
+// the refreshed files replace the archived ones, keeping their path even
+// if the metadata the templates use changed since
+func loadArchived(video *Video) error {
+	path, err := readIndex(video.ID)
+	if err != nil {
+		path = findManifest(video)
+	}
+	manifest, err := readManifest(path)
+	if err != nil {
+		return err
+	}
+	if manifest.ID != video.ID {
+		return errors.New(path + " is the manifest of " + manifest.ID)
+	}
+	dir := filepath.Dir(path) + "/"
+	fileName := strings.TrimSuffix(filepath.Base(path), manifestExtension)
+
+	data, err := ioutil.ReadFile(dir + fileName + ".info.json")
+	if err != nil {
+		return err
+	}
+	var info map[string]interface{}
+	err = json.Unmarshal(data, &info)
+	if err != nil {
+		return err
+	}
+
+	video.Path = dir
+	video.FileName = fileName
+	video.previousManifest = manifest
+	video.previousInfo = info
+	return nil
+}
+
+// diffInfoJSON returns the top-level fields that differ between two info.json
+func diffInfoJSON(previous, current map[string]interface{}) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+	for field, old := range previous {
+		if !refreshIgnoredFields[field] && !reflect.DeepEqual(old, current[field]) {
+			changes[field] = FieldChange{Old: old, New: current[field]}
+		}
+	}
+	for field, value := range current {
+		if _, ok := previous[field]; !ok && !refreshIgnoredFields[field] {
+			changes[field] = FieldChange{Old: nil, New: value}
+		}
+	}
+	return changes
+}
+
+// stageArchivedFile copies a file of the archived video to the
+// staging directory, keeping its entry of the manifest
+func stageArchivedFile(video *Video, artifact Artifact) error {
+	in, err := os.Open(video.Path + artifact.Name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(video.StagingPath + artifact.Name)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		return err
+	}
+	video.Files = append(video.Files, artifact)
+	return nil
+}
+
+// writeChanges carries over the changes found by the previous refreshes,
+// and adds those between the archived info.json and the new one
+func writeChanges(video *Video) error {
+	var previousFetchedAt time.Time
+	for _, artifact := range video.previousManifest.Files {
+		if artifact.Name == video.FileName+".info.json" {
+			previousFetchedAt = artifact.FetchedAt
+		}
+		if !strings.HasSuffix(artifact.Name, changesExtension) {
+			continue
+		}
+		err := stageArchivedFile(video, artifact)
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(&video.InfoJSON)
+	if err != nil {
+		return err
+	}
+	var current map[string]interface{}
+	err = json.Unmarshal(data, &current)
+	if err != nil {
+		return err
+	}
+
+	fields := diffInfoJSON(video.previousInfo, current)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	changes := Changes{
+		ID:                video.ID,
+		PreviousFetchedAt: previousFetchedAt,
+		FetchedAt:         time.Now().UTC(),
+		Fields:            fields,
+	}
+	JSON, err := JSONMarshalIndentNoEscapeHTML(&changes, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	changesFile, err := createFile(video, "."+changes.FetchedAt.Format("20060102T150405Z")+changesExtension)
+	if err != nil {
+		return err
+	}
+	defer changesFile.Close()
+
+	_, err = changesFile.Write(JSON)
+	return err
+}
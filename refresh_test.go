@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// editArchivedInfoJSON changes fields of an archived info.json,
+// keeping its manifest in sync so that it's still complete
+func editArchivedInfoJSON(t *testing.T, dir string, fields map[string]interface{}) {
+	infos, _ := filepath.Glob(filepath.Join(dir, "*.info.json"))
+	manifests, _ := filepath.Glob(filepath.Join(dir, "*"+manifestExtension))
+	if len(infos) != 1 || len(manifests) != 1 {
+		t.Fatalf("info.json = %q, manifests = %q", infos, manifests)
+	}
+
+	var info map[string]interface{}
+	data, _ := ioutil.ReadFile(infos[0])
+	if err := json.Unmarshal(data, &info); err != nil {
+		t.Fatal(err)
+	}
+	for field, value := range fields {
+		info[field] = value
+	}
+	data, _ = json.Marshal(info)
+	ioutil.WriteFile(infos[0], data, 0644)
+
+	manifest, err := readManifest(manifests[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, artifact := range manifest.Files {
+		if artifact.Name == filepath.Base(infos[0]) {
+			manifest.Files[i].Size, manifest.Files[i].SHA256, _ = hashFile(infos[0])
+		}
+	}
+	data, _ = json.Marshal(manifest)
+	ioutil.WriteFile(manifests[0], data, 0644)
+}
+
+func TestRefresh(t *testing.T) {
+	defer useFixtures(t)()
+
+	ID := legacyFixtureID
+	dir := filepath.Join(arguments.Output, ID[:1], ID[:3], ID)
+	if err := archiveID(context.Background(), ID); err != nil {
+		t.Fatal(err)
+	}
+	editArchivedInfoJSON(t, dir, map[string]interface{}{
+		"title":      "Old title",
+		"view_count": 1,
+	})
+
+	arguments.Refresh = true
+	if err := archiveID(context.Background(), ID); err != nil {
+		t.Fatal(err)
+	}
+
+	changesFiles, _ := filepath.Glob(filepath.Join(dir, "*"+changesExtension))
+	if len(changesFiles) != 1 {
+		t.Fatalf("changes files = %q", changesFiles)
+	}
+	data, _ := ioutil.ReadFile(changesFiles[0])
+	var changes Changes
+	if err := json.Unmarshal(data, &changes); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Fields) != 2 || changes.Fields["title"].Old != "Old title" ||
+		changes.Fields["view_count"].Old != float64(1) || changes.PreviousFetchedAt.IsZero() {
+		t.Errorf("changes = %+v", changes)
+	}
+
+	// nothing changed since, the previous changes are kept and listed in the manifest
+	if err := archiveID(context.Background(), ID); err != nil {
+		t.Fatal(err)
+	}
+	again, _ := filepath.Glob(filepath.Join(dir, "*"+changesExtension))
+	if len(again) != 1 || again[0] != changesFiles[0] {
+		t.Errorf("changes files = %q", again)
+	}
+	for _, job := range listedVideos(t) {
+		if report := verifyVideo(job); len(report.Problems) != 0 {
+			t.Errorf("%s: unexpected problems %q", report.ID, report.Problems)
+		}
+	}
+}
+
+func TestRefreshMovedArchive(t *testing.T) {
+	defer useFixtures(t)()
+	arguments.DirTemplate = "%(title)s/%(id)s"
+
+	ID := legacyFixtureID
+	if err := archiveID(context.Background(), ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// the video was archived under its previous title
+	current, _ := filepath.Glob(filepath.Join(arguments.Output, "*", ID))
+	if len(current) != 1 {
+		t.Fatalf("archived in %q", current)
+	}
+	dir := filepath.Join(arguments.Output, "Old title", ID)
+	if err := os.Rename(filepath.Dir(current[0]), filepath.Dir(dir)); err != nil {
+		t.Fatal(err)
+	}
+	manifests, _ := filepath.Glob(filepath.Join(dir, "*"+manifestExtension))
+	if len(manifests) != 1 || writeIndex(ID, manifests[0]) != nil {
+		t.Fatalf("manifests = %q", manifests)
+	}
+	editArchivedInfoJSON(t, dir, map[string]interface{}{"title": "Old title"})
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("kept"), 0644)
+
+	arguments.Refresh = true
+	if err := archiveID(context.Background(), ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// refreshed in place, without a second copy under the new title
+	if archived, _ := filepath.Glob(filepath.Join(arguments.Output, "*", ID)); len(archived) != 1 || archived[0] != dir {
+		t.Errorf("archived in %q, want %s", archived, dir)
+	}
+	if changes, _ := filepath.Glob(filepath.Join(dir, "*"+changesExtension)); len(changes) != 1 {
+		t.Errorf("changes files = %q", changes)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "notes.txt")); string(data) != "kept" {
+		t.Errorf("notes.txt after refresh = %q, %v", data, err)
+	}
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("directory after refresh = %v, %v", info, err)
+	}
+	if staged, _ := ioutil.ReadDir(stagingRoot()); len(staged) != 0 {
+		t.Errorf("%d entries left in staging", len(staged))
+	}
+	for _, job := range listedVideos(t) {
+		if report := verifyVideo(job); len(report.Problems) != 0 {
+			t.Errorf("%s: unexpected problems %q", report.ID, report.Problems)
+		}
+	}
+}
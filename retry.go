@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Classes of failures, each with its own retry policy
+const (
+	// network errors, 5xx, 429, 408 and 403, likely to succeed
+	// later, YouTube answers 403 to the clients it throttles, and
+	// videos unavailable to the proxy or session that asked
+	failureTransient = "transient"
+	// 404 and videos unavailable to everyone, never retried
+	failurePermanent = "permanent"
+	// the page didn't have what we expected, retried a few times
+	failureParser = "parser"
+)
+
+// Number of failures kept in the history of each ID
+const maxFailureHistory = 20
+
+// RetryPolicy structure containing how a class of failures is retried
+type RetryPolicy struct {
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	MaxAttempts int
+}
+
+var retryPolicies = map[string]RetryPolicy{
+	failureTransient: {BaseDelay: 10 * time.Second, MaxDelay: 10 * time.Minute, MaxAttempts: 6},
+	failureParser:    {BaseDelay: time.Minute, MaxDelay: 30 * time.Minute, MaxAttempts: 3},
+	failurePermanent: {MaxAttempts: 1},
+}
+
+// StatusError is returned when a request gets an unexpected status code
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return "status code " + strconv.Itoa(e.StatusCode) + " when requesting " + e.URL
+}
+
+// Failure structure containing a failed attempt to archive a video
+type Failure struct {
+	Time  time.Time `json:"time"`
+	Class string    `json:"class"`
+	Error string    `json:"error"`
+}
+
+// FailureHistory structure containing the last failures of a video, and
+// the number of failures of each class since it was last queued
+type FailureHistory struct {
+	Failures []Failure      `json:"failures,omitempty"`
+	Retries  map[string]int `json:"retries,omitempty"`
+}
+
+// classifyError tells the class of the failure behind err
+func classifyError(err error) string {
+	switch e := err.(type) {
+	case *UnavailableError:
+		// another proxy or session may be allowed to watch the others
+		if e.Permanent() {
+			return failurePermanent
+		}
+		return failureTransient
+	case *StatusError:
+		if e.StatusCode == 403 || e.StatusCode == 408 || e.StatusCode == 429 || e.StatusCode >= 500 {
+			return failureTransient
+		}
+		return failurePermanent
+	case net.Error, *os.PathError, *os.LinkError:
+		return failureTransient
+	}
+
+	switch err {
+	case context.DeadlineExceeded, context.Canceled, io.ErrUnexpectedEOF, errNoHealthyProxy, errConsentRequired:
+		return failureTransient
+	}
+	return failureParser
+}
+
+// retryDelay returns the exponential backoff before the nth retry,
+// with a random jitter so that failed videos don't retry all at once
+func retryDelay(policy RetryPolicy, n int) time.Duration {
+	delay := policy.BaseDelay
+	for i := 1; i < n && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// record adds the failure to the history, and returns when the
+// video should be tried again, or false if it shouldn't be
+func (h *FailureHistory) record(err error, now time.Time) (time.Time, bool) {
+	class := classifyError(err)
+
+	h.Failures = append(h.Failures, Failure{Time: now, Class: class, Error: err.Error()})
+	if len(h.Failures) > maxFailureHistory {
+		h.Failures = h.Failures[len(h.Failures)-maxFailureHistory:]
+	}
+
+	if h.Retries == nil {
+		h.Retries = make(map[string]int)
+	}
+	h.Retries[class]++
+
+	policy := retryPolicies[class]
+	if h.Retries[class] >= policy.MaxAttempts {
+		return time.Time{}, false
+	}
+	return now.Add(retryDelay(policy, h.Retries[class])), true
+}
+
+// reset forgets the retries, but not the history, once a
+// video is archived or queued again
+func (h *FailureHistory) reset() {
+	h.Retries = nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&url.Error{Op: "Get", URL: "https://www.youtube.com", Err: errors.New("connection reset")}, failureTransient},
+		{context.DeadlineExceeded, failureTransient},
+		{errConsentRequired, failureTransient},
+		{&StatusError{URL: "the video page", StatusCode: 503}, failureTransient},
+		{&StatusError{URL: "the video page", StatusCode: 429}, failureTransient},
+		{&StatusError{URL: "the video page", StatusCode: 403}, failureTransient},
+		{&StatusError{URL: "the video page", StatusCode: 408}, failureTransient},
+		{&StatusError{URL: "the video page", StatusCode: 404}, failurePermanent},
+		{&UnavailableError{Reason: unavailablePrivate}, failurePermanent},
+		{&UnavailableError{Reason: unavailableAgeGated}, failureTransient},
+		{&UnavailableError{Reason: unavailableRegionBlocked}, failureTransient},
+		{&UnavailableError{Reason: unavailableUnknown}, failureTransient},
+		{errFieldMissing, failureParser},
+		{errors.New("title of the video is empty, cancelation"), failureParser},
+	}
+
+	for _, test := range tests {
+		if got := classifyError(test.err); got != test.want {
+			t.Errorf("classifyError(%v) = %s, want %s", test.err, got, test.want)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	for n, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 10 * time.Second} {
+		delay := retryDelay(policy, n)
+		if delay < max/2 || delay > max {
+			t.Errorf("retry %d: delay %s, want between %s and %s", n, delay, max/2, max)
+		}
+	}
+}
+
+func TestFailureHistory(t *testing.T) {
+	var history FailureHistory
+	now := time.Now()
+	parserErr := errors.New("no title")
+
+	for i := 1; i < retryPolicies[failureParser].MaxAttempts; i++ {
+		if retryAt, ok := history.record(parserErr, now); !ok || !retryAt.After(now) {
+			t.Fatalf("failure %d: retry at %s, %v", i, retryAt, ok)
+		}
+	}
+	if _, ok := history.record(parserErr, now); ok {
+		t.Error("retried after running out of attempts")
+	}
+
+	// permanent failures are never retried
+	history.reset()
+	if _, ok := history.record(&StatusError{URL: "the video page", StatusCode: 404}, now); ok {
+		t.Error("permanent failure retried")
+	}
+
+	if len(history.Failures) != retryPolicies[failureParser].MaxAttempts+1 {
+		t.Errorf("history = %+v", history.Failures)
+	}
+}
+
+func TestMemoryQueueRetries(t *testing.T) {
+	ctx := context.Background()
+	q := newMemoryQueue()
+	q.Push(ctx, "MPBfVp0tB8E")
+
+	IDs, err := q.Fetch(ctx, 10)
+	if err != nil || len(IDs) != 1 {
+		t.Fatalf("Fetch() = %q, %v", IDs, err)
+	}
+
+	// the retry isn't due yet, the queue waits for it
+	q.Nack(ctx, IDs[0], &StatusError{URL: "the video page", StatusCode: 500})
+	if IDs, err := q.Fetch(ctx, 10); err != nil || len(IDs) != 0 {
+		t.Fatalf("Fetch() = %q, %v", IDs, err)
+	}
+
+	q.retrying[IDs[0]] = time.Now()
+	if IDs, err = q.Fetch(ctx, 10); err != nil || len(IDs) != 1 {
+		t.Fatalf("Fetch() = %q, %v", IDs, err)
+	}
+
+	q.Nack(ctx, IDs[0], &UnavailableError{Reason: unavailableRemoved})
+	if _, err := q.Fetch(ctx, 10); err != io.EOF {
+		t.Errorf("Fetch() error = %v, want io.EOF", err)
+	}
+	if len(q.history[IDs[0]].Failures) != 2 {
+		t.Errorf("history = %+v", q.history[IDs[0]])
+	}
+}
+
+func TestBoltQueueRetries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "youtube-ma-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := openBoltQueue(filepath.Join(dir, "queue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	ctx := context.Background()
+	ID := "MPBfVp0tB8E"
+	q.Push(ctx, ID)
+	q.Fetch(ctx, 10)
+	q.Nack(ctx, ID, &StatusError{URL: "the video page", StatusCode: 429})
+
+	var entry *QueueEntry
+	q.db.View(func(tx *bolt.Tx) error {
+		entry, err = getEntry(tx, ID)
+		return err
+	})
+	if entry.State != stateRetrying || entry.NotBefore.IsZero() || len(entry.Failures) != 1 {
+		t.Fatalf("entry = %+v", entry)
+	}
+
+	// waiting for the retry
+	if IDs, err := q.Fetch(ctx, 10); err != nil || len(IDs) != 0 {
+		t.Fatalf("Fetch() = %q, %v", IDs, err)
+	}
+
+	q.db.Update(func(tx *bolt.Tx) error {
+		return dueRetries(tx, entry.NotBefore)
+	})
+	if IDs, err := q.Fetch(ctx, 10); err != nil || len(IDs) != 1 {
+		t.Fatalf("Fetch() = %q, %v", IDs, err)
+	}
+
+	q.Ack(ctx, ID)
+	if _, err := q.Fetch(ctx, 10); err != io.EOF {
+		t.Errorf("Fetch() error = %v, want io.EOF", err)
+	}
+}
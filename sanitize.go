@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"unicode/utf8"
+)
+
+// Most filesystems limit a file name to 255 bytes, the base name of the
+// files of a video leaves room for the longest extensions we add to it
+const (
+	maxFileNameBytes     = 255
+	maxBaseFileNameBytes = maxFileNameBytes - 32
+)
+
+// Characters forbidden in file names on Windows, macOS or Linux
+const reservedCharacters = `<>:"/\|?*`
+
+// Device names that can't be used as file names on Windows,
+// whatever their extension is
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM0": true, "COM1": true, "COM2": true, "COM3": true, "COM4": true,
+	"COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT0": true, "LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true,
+	"LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// replaceReservedCharacters replaces the reserved characters, control
+// characters and invalid UTF-8 bytes of the name with underscores
+func replaceReservedCharacters(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if r == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(name[i:]); size == 1 {
+				b.WriteByte('_')
+				continue
+			}
+		}
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(reservedCharacters, r) {
+			b.WriteByte('_')
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// nameHash returns a short hash of the original name, added to the names
+// that had to be changed so that two different names never end up the same
+func nameHash(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return "-" + hex.EncodeToString(sum[:])[:12]
+}
+
+// escapeFileName replaces the reserved characters of the name, adding
+// the hash of the original name if any had to be replaced
+func escapeFileName(name string) string {
+	escaped := replaceReservedCharacters(name)
+	if escaped != name {
+		escaped += nameHash(name)
+	}
+	return escaped
+}
+
+// sanitizeFileName turns the name into a file name valid on every platform
+// and at most maxBytes long. The same name always gives the same result, and
+// a name that had to be changed or truncated ends with a hash of the original
+// one, so that "a/b" and "a:b", or two long names sharing the same beginning,
+// don't collide.
+func sanitizeFileName(name string, maxBytes int) string {
+	sanitized := replaceReservedCharacters(name)
+
+	// Windows silently drops trailing dots and spaces
+	trimmed := strings.TrimRight(sanitized, ". ")
+	sanitized = trimmed + strings.Repeat("_", len(sanitized)-len(trimmed))
+	if sanitized == "" {
+		sanitized = "_"
+	}
+
+	// Windows device names, with or without an extension
+	base := strings.SplitN(sanitized, ".", 2)[0]
+	if reservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+		sanitized = "_" + sanitized
+	}
+
+	if sanitized == name && len(sanitized) <= maxBytes {
+		return sanitized
+	}
+
+	suffix := nameHash(name)
+	if len(sanitized)+len(suffix) <= maxBytes {
+		return sanitized + suffix
+	}
+
+	// Truncate at a rune boundary, leaving room for the hash
+	cut := maxBytes - len(suffix)
+	for cut > 0 && !utf8.RuneStart(sanitized[cut]) {
+		cut--
+	}
+	return strings.TrimRight(sanitized[:cut], ". ") + suffix
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"plain_title", "plain_title"},
+		{"CONSOLE", "CONSOLE"},
+		{"日本語のタイトル", "日本語のタイトル"},
+		// changed names end with the hash of the original one
+		{`a<b>c:d"e/f\g|h?i*j`, "a_b_c_d_e_f_g_h_i_j"},
+		{"nul\x00tab\tdel\x7f", "nul_tab_del_"},
+		{"bad\xffutf8", "bad_utf8"},
+		{"trailing...", "trailing___"},
+		{"trailing dot. ", "trailing dot__"},
+		{"..", "__"},
+		{"", "_"},
+		{"CON", "_CON"},
+		{"con.txt", "_con.txt"},
+		{"Lpt1", "_Lpt1"},
+	}
+
+	for _, test := range tests {
+		want := test.want
+		if want != test.name {
+			want += nameHash(test.name)
+		}
+		if got := sanitizeFileName(test.name, maxFileNameBytes); got != want {
+			t.Errorf("sanitizeFileName(%q) = %q, want %q", test.name, got, want)
+		}
+	}
+}
+
+func TestSanitizeFileNameCollisions(t *testing.T) {
+	names := []string{"a/b", "a:b", "a_b", "a?b"}
+	seen := make(map[string]string)
+	for _, name := range names {
+		got := sanitizeFileName(name, maxFileNameBytes)
+		if other, ok := seen[got]; ok {
+			t.Errorf("%q and %q both give %q", other, name, got)
+		}
+		seen[got] = name
+
+		escaped := escapeFileName(name)
+		if other, ok := seen["escaped "+escaped]; ok {
+			t.Errorf("escapeFileName(): %q and %q both give %q", other, name, escaped)
+		}
+		seen["escaped "+escaped] = name
+	}
+}
+
+func TestSanitizeFileNameTruncates(t *testing.T) {
+	long := strings.Repeat("日本語", 40)
+	got := sanitizeFileName(long, maxBaseFileNameBytes)
+	if len(got) > maxBaseFileNameBytes {
+		t.Errorf("%d bytes, want at most %d", len(got), maxBaseFileNameBytes)
+	}
+	if !utf8.ValidString(got) {
+		t.Errorf("%q was not cut at a rune boundary", got)
+	}
+	if again := sanitizeFileName(long, maxBaseFileNameBytes); again != got {
+		t.Errorf("not deterministic: %q then %q", got, again)
+	}
+	if other := sanitizeFileName(long+"2", maxBaseFileNameBytes); other == got {
+		t.Errorf("two long names collide on %q", got)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// youtubeOrigin is the origin the SAPISIDHASH of signed-in requests is computed for
+const youtubeOrigin = "https://www.youtube.com"
+
+// Cookies holding the SAPISID of a signed-in browser, the
+// second one is the only one left in recent exports
+var sapisidCookieNames = []string{"SAPISID", "__Secure-3PAPISID"}
+
+// Session structure containing the cookies of a signed-in
+// account, and the client sending them
+type Session struct {
+	Path   string
+	Jar    *CookieJar
+	Client *http.Client
+}
+
+// sessions are the accounts given with --session, each
+// worker sends all its requests through one of them
+var sessions []*Session
+
+type sessionKey struct{}
+
+// newSession reads the cookies exported from a signed-in browser
+func newSession(path string) (*Session, error) {
+	jar := newCookieJar()
+	err := jar.loadCookies(path)
+	if err != nil {
+		return nil, err
+	}
+	if jar.sapisid() == "" {
+		return nil, errors.New("no SAPISID cookie in " + path + ", export the cookies of a signed-in browser")
+	}
+	jar.setConsent()
+
+	return &Session{
+		Path: path,
+		Jar:  jar,
+		Client: &http.Client{
+			Transport: &YtmaTripper{
+				Tripper: &SessionTripper{
+					Jar:     jar,
+					Tripper: &ConsentTripper{Jar: jar, Tripper: routeTripper{}},
+				},
+			},
+			Jar: jar,
+		},
+	}, nil
+}
+
+// withSession makes the requests done with ctx use the session
+func withSession(ctx context.Context, session *Session) context.Context {
+	if session == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// sessionOf returns the session of the context, nil if there is none
+func sessionOf(ctx context.Context) *Session {
+	session, _ := ctx.Value(sessionKey{}).(*Session)
+	return session
+}
+
+// sapisid returns the SAPISID cookie of the jar, empty if it isn't signed in
+func (j *CookieJar) sapisid() string {
+	for _, name := range sapisidCookieNames {
+		for _, c := range j.Cookies(youtubeURL) {
+			if c.Name == name {
+				return c.Value
+			}
+		}
+	}
+	return ""
+}
+
+// sapisidHash is the Authorization header YouTube expects from
+// signed-in clients, along with their cookies
+func sapisidHash(sapisid string, origin string, now time.Time) string {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	hash := sha1.Sum([]byte(timestamp + " " + sapisid + " " + origin))
+	return "SAPISIDHASH " + timestamp + "_" + hex.EncodeToString(hash[:])
+}
+
+// SessionTripper signs the requests to YouTube in as the account of the session
+type SessionTripper struct {
+	Jar     *CookieJar
+	Tripper http.RoundTripper
+}
+
+func (t *SessionTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	if host != "youtube.com" && !strings.HasSuffix(host, ".youtube.com") {
+		return t.Tripper.RoundTrip(req)
+	}
+
+	sapisid := t.Jar.sapisid()
+	if sapisid == "" {
+		return t.Tripper.RoundTrip(req)
+	}
+
+	signed := req.Clone(req.Context())
+	signed.Header.Set("Authorization", sapisidHash(sapisid, youtubeOrigin, time.Now()))
+	signed.Header.Set("X-Origin", youtubeOrigin)
+	signed.Header.Set("X-Goog-AuthUser", "0")
+	return t.Tripper.RoundTrip(signed)
+}
+
+// loadSessions reads the cookies files of --session
+func loadSessions(paths []string) ([]*Session, error) {
+	var loaded []*Session
+	for _, path := range paths {
+		session, err := newSession(path)
+		if err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, session)
+	}
+	return loaded, nil
+}
+
+// saveSessions writes the cookies YouTube updated back to the session files
+func saveSessions() error {
+	for _, session := range sessions {
+		err := session.Jar.saveCookies(session.Path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSapisidHash(t *testing.T) {
+	got := sapisidHash("abcdef/ghijk", youtubeOrigin, time.Unix(1600000000, 0))
+	if want := "SAPISIDHASH 1600000000_5f6dba08b85569c8367d08d12c40d32bc07160c0"; got != want {
+		t.Errorf("sapisidHash() = %s, want %s", got, want)
+	}
+}
+
+// headerTripper records the headers of the last request
+type headerTripper struct {
+	Header *http.Header
+}
+
+func (t headerTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	*t.Header = req.Header
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func TestSession(t *testing.T) {
+	dir, err := ioutil.TempDir("", "youtube-ma-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	signedOut := filepath.Join(dir, "signed-out.txt")
+	ioutil.WriteFile(signedOut, []byte(".youtube.com\tTRUE\t/\tTRUE\t4102444800\tPREF\thl=en\n"), 0600)
+	if _, err := newSession(signedOut); err == nil {
+		t.Error("session without SAPISID accepted")
+	}
+
+	path := filepath.Join(dir, "cookies.txt")
+	ioutil.WriteFile(path, []byte(".youtube.com\tTRUE\t/\tTRUE\t4102444800\t__Secure-3PAPISID\tabcdef/ghijk\n"), 0600)
+	loaded, err := loadSessions([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := loaded[0]
+
+	// the workers with a session use its client
+	ctx := withSession(context.Background(), session)
+	if getHttpClient(ctx) != session.Client || getHttpClient(context.Background()) != httpClient {
+		t.Error("wrong client for the session")
+	}
+
+	// only the requests to YouTube are signed
+	var header http.Header
+	tripper := &SessionTripper{Jar: session.Jar, Tripper: headerTripper{&header}}
+	for URL, signed := range map[string]bool{
+		"https://www.youtube.com/watch?v=MPBfVp0tB8E":      true,
+		"https://www.youtube.com/youtubei/v1/player":       true,
+		"http://i3.ytimg.com/vi/MPBfVp0tB8E/mqdefault.jpg": false,
+	} {
+		req, _ := http.NewRequest(http.MethodGet, URL, nil)
+		tripper.RoundTrip(req)
+		if (header.Get("Authorization") != "") != signed || req.Header.Get("Authorization") != "" {
+			t.Errorf("%s: Authorization = %q", URL, header.Get("Authorization"))
+		}
+		if signed && header.Get("X-Origin") != youtubeOrigin {
+			t.Errorf("%s: X-Origin = %q", URL, header.Get("X-Origin"))
+		}
+	}
+}
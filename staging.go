@@ -0,0 +1,194 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Videos are assembled in this directory of the output, and only
+// moved to their final path once every file has been written
+const stagingDirName = ".staging"
+
+func stagingRoot() string {
+	return arguments.Output + "/" + stagingDirName
+}
+
+// cleanStaging removes what was left in the staging directory
+// by a previous run that crashed or got killed
+func cleanStaging() error {
+	return os.RemoveAll(stagingRoot())
+}
+
+// genPath creates the staging directory of the video
+func genPath(video *Video) error {
+	err := os.MkdirAll(stagingRoot(), 0755)
+	if err != nil {
+		return err
+	}
+
+	dir, err := ioutil.TempDir(stagingRoot(), video.ID+"-")
+	if err != nil {
+		return err
+	}
+	video.StagingPath = dir + "/"
+	return nil
+}
+
+// syncPath flushes a file or a directory to the disk
+func syncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// commitFiles flushes the staged files of the video and moves them
+// to video.Path, so that the video is either complete or absent
+func commitFiles(video *Video) error {
+	// The info.json and the manifest come last, a directory holding an
+	// info.json without manifest is taken for an archive older than them
+	info := video.FileName + ".info.json"
+	manifest := video.FileName + manifestExtension
+	names := []string{}
+	for _, artifact := range video.Files {
+		if artifact.Name != info {
+			names = append(names, artifact.Name)
+		}
+	}
+	names = append(names, info, manifest)
+
+	for _, name := range names {
+		err := syncPath(video.StagingPath + name)
+		if err != nil {
+			return err
+		}
+	}
+	err := syncPath(video.StagingPath)
+	if err != nil {
+		return err
+	}
+
+	finalPath := strings.TrimSuffix(video.Path, "/")
+	err = os.MkdirAll(filepath.Dir(finalPath), 0755)
+	if err != nil {
+		return err
+	}
+
+	if dirTemplateIsPerVideo() {
+		_, err = os.Stat(finalPath)
+		if err == nil && video.previousManifest != nil {
+			return swapDir(video, finalPath, names)
+		}
+		if os.IsNotExist(err) {
+			// Nothing there yet, move the whole directory at once,
+			// TempDir created it for its owner only
+			err = os.Chmod(video.StagingPath, 0755)
+			if err != nil {
+				return err
+			}
+			err = os.Rename(video.StagingPath, finalPath)
+			if err != nil {
+				return err
+			}
+			return syncPath(filepath.Dir(finalPath))
+		}
+	}
+
+	// The directory is shared with other videos, or already holds files
+	// of this one such as a tombstone, move the files one by one into it,
+	// the manifest last as it marks the video as archived, once the
+	// previous one is gone so that a mix of both is never taken as complete
+	err = os.MkdirAll(finalPath, 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(video.Path + manifest)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, name := range names {
+		err = os.Rename(video.StagingPath+name, video.Path+name)
+		if err != nil {
+			return err
+		}
+	}
+	os.Remove(video.StagingPath)
+	return syncPath(finalPath)
+}
+
+// swapDir replaces the directory of a refreshed video by the staged one,
+// which gets whatever the staged files don't replace, such as a tombstone.
+// A crash between the two renames leaves the video absent, and archived
+// again by the next run, but never a mix of both captures.
+func swapDir(video *Video, finalPath string, names []string) error {
+	staged := make(map[string]bool)
+	for _, name := range names {
+		staged[name] = true
+	}
+
+	files, err := ioutil.ReadDir(finalPath)
+	if err != nil {
+		return err
+	}
+	var moved []string
+	for _, file := range files {
+		if staged[file.Name()] {
+			continue
+		}
+		if file.IsDir() {
+			err = os.Rename(finalPath+"/"+file.Name(), video.StagingPath+file.Name())
+			moved = append(moved, file.Name())
+		} else {
+			err = os.Link(finalPath+"/"+file.Name(), video.StagingPath+file.Name())
+		}
+		if err != nil {
+			restoreDirs(video.StagingPath, finalPath, moved)
+			return err
+		}
+	}
+
+	// TempDir created the staging directory for its owner only
+	err = os.Chmod(video.StagingPath, 0755)
+	if err == nil {
+		err = syncPath(video.StagingPath)
+	}
+	if err != nil {
+		restoreDirs(video.StagingPath, finalPath, moved)
+		return err
+	}
+
+	previous := strings.TrimSuffix(video.StagingPath, "/") + ".previous"
+	err = os.Rename(finalPath, previous)
+	if err != nil {
+		restoreDirs(video.StagingPath, finalPath, moved)
+		return err
+	}
+	err = os.Rename(video.StagingPath, finalPath)
+	if err != nil {
+		os.Rename(previous, finalPath)
+		restoreDirs(video.StagingPath, finalPath, moved)
+		return err
+	}
+
+	os.RemoveAll(previous)
+	return syncPath(filepath.Dir(finalPath))
+}
+
+// restoreDirs moves the directories swapDir moved to staging back
+func restoreDirs(stagingPath string, finalPath string, names []string) {
+	for _, name := range names {
+		os.Rename(stagingPath+name, finalPath+"/"+name)
+	}
+}
+
+// removeFiles throws away the staged files of the video
+func removeFiles(video *Video) {
+	if video.StagingPath != "" {
+		os.RemoveAll(video.StagingPath)
+	}
+	video.Files = nil
+}
@@ -1,37 +1,48 @@
 package main
 
 import (
+	"context"
 	"encoding/xml"
-	"errors"
 	"io"
 	"io/ioutil"
-	"os"
+	"net/url"
+	"regexp"
 )
 
+// langCodePattern matches the language codes of the subtitles, such as
+// en, pt-BR or zh-Hans, they end up in file names and URLs
+var langCodePattern = regexp.MustCompile(`^[A-Za-z0-9]{1,8}([-_.][A-Za-z0-9]{1,8})*$`)
+
 func addSubToJSON(video *Video, langCode string) {
-	urlXML := "http://www.youtube.com/api/timedtext?lang=" + langCode + "&v=" + video.ID
-	urlTTML := "http://www.youtube.com/api/timedtext?lang=" + langCode + "&v=" + video.ID + "&fmt=ttml&name="
-	urlVTT := "http://www.youtube.com/api/timedtext?lang=" + langCode + "&v=" + video.ID + "&fmt=vtt&name="
+	lang := url.QueryEscape(langCode)
+	urlXML := "http://www.youtube.com/api/timedtext?lang=" + lang + "&v=" + video.ID
+	urlTTML := "http://www.youtube.com/api/timedtext?lang=" + lang + "&v=" + video.ID + "&fmt=ttml&name="
+	urlVTT := "http://www.youtube.com/api/timedtext?lang=" + lang + "&v=" + video.ID + "&fmt=vtt&name="
 	video.InfoJSON.subLock.Lock()
 	video.InfoJSON.Subtitles[langCode] = append(video.InfoJSON.Subtitles[langCode], Subtitle{urlXML, "xml"}, Subtitle{urlTTML, "ttml"}, Subtitle{urlVTT, "vtt"})
 	video.InfoJSON.subLock.Unlock()
 }
 
-func downloadSub(video *Video, langCode string, lang string) error {
+func downloadSub(ctx context.Context, video *Video, langCode string, lang string) error {
 	addSubToJSON(video, langCode)
 
 	// generate subtitle URL
-	url := "http://www.youtube.com/api/timedtext?lang=" + langCode + "&v=" + video.ID
+	subURL := "http://www.youtube.com/api/timedtext?lang=" + url.QueryEscape(langCode) + "&v=" + video.ID
 
 	// get the data
-	resp, err := getHttpClient().Get(url)
+	resp, err := httpGet(ctx, subURL)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	// don't save an error page as the subtitles
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{URL: "the " + langCode + " subtitles", StatusCode: resp.StatusCode}
+	}
+
 	// create the file
-	out, err := os.Create(video.Path + video.ID + "_" + video.Title + "." + langCode + ".xml")
+	out, err := createFile(video, "."+langCode+".xml")
 	if err != nil {
 		return err
 	}
@@ -46,11 +57,11 @@ func downloadSub(video *Video, langCode string, lang string) error {
 	return nil
 }
 
-func fetchSubs(video *Video) error {
+func fetchSubs(ctx context.Context, video *Video) error {
 	var tracks Tracklist
 
 	// request subtitles list
-	res, err := getHttpClient().Get("https://video.google.com/timedtext?hl=en&type=list&v=" + video.ID)
+	res, err := httpGet(ctx, "https://video.google.com/timedtext?hl=en&type=list&v="+video.ID)
 	if err != nil {
 		return err
 	}
@@ -58,7 +69,7 @@ func fetchSubs(video *Video) error {
 
 	// check status, exit if != 200
 	if res.StatusCode != 200 {
-		return errors.New("status code of subtitles list != 200, cancelation")
+		return &StatusError{URL: "the subtitles list", StatusCode: res.StatusCode}
 	}
 
 	// reading tracks list as a byte array
@@ -70,7 +81,11 @@ func fetchSubs(video *Video) error {
 	// download the subtitles
 	xml.Unmarshal(data, &tracks)
 	for _, track := range tracks.Tracks {
-		err = downloadSub(video, track.LangCode, track.Lang)
+		// the code names the file, it can't be trusted to be a valid one
+		if !langCodePattern.MatchString(track.LangCode) {
+			continue
+		}
+		err = downloadSub(ctx, video, track.LangCode, track.Lang)
 		if err != nil {
 			return err
 		}
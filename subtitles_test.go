@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestLangCodePattern(t *testing.T) {
+	tests := map[string]bool{
+		"en":        true,
+		"pt-BR":     true,
+		"zh-Hans":   true,
+		"a.en":      true,
+		"":          false,
+		"../../x":   false,
+		"en/../..":  false,
+		"en\x00":    false,
+		"en&v=evil": false,
+		"..":        false,
+	}
+
+	for code, want := range tests {
+		if got := langCodePattern.MatchString(code); got != want {
+			t.Errorf("langCodePattern.MatchString(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Default output templates, giving output/X/XYZ/ID/ID_Title.ext
+const (
+	defaultDirTemplate  = "%(id).1s/%(id).3s/%(id)s"
+	defaultFileTemplate = "%(id)s_%(title)s"
+)
+
+// templatePattern matches %(field)s placeholders with an optional
+// width and precision, as in yt-dlp output templates
+var templatePattern = regexp.MustCompile(`%(%|\((\w+)\)(-?\d*)(\.\d+)?([sd]))`)
+
+// templateFields returns the values available in the templates: every
+// scalar field of the info.json, with title being the file-safe title
+func templateFields(video *Video) map[string]interface{} {
+	fields := make(map[string]interface{})
+
+	data, err := json.Marshal(&video.InfoJSON)
+	if err == nil {
+		json.Unmarshal(data, &fields)
+	}
+
+	for k, v := range fields {
+		switch v.(type) {
+		case string, float64:
+		default:
+			delete(fields, k)
+		}
+	}
+
+	fields["id"] = video.ID
+	fields["title"] = video.Title
+	return fields
+}
+
+// renderTemplate replaces the placeholders of the template with the
+// fields, missing fields are rendered as NA
+func renderTemplate(template string, fields map[string]interface{}) string {
+	return templatePattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		m := templatePattern.FindStringSubmatch(placeholder)
+		if m[1] == "%" {
+			return "%"
+		}
+		name, width, precision, verb := m[2], m[3], m[4], m[5]
+
+		value, ok := fields[name]
+		if !ok {
+			value = "NA"
+		}
+
+		switch v := value.(type) {
+		case float64:
+			if verb == "d" {
+				value = int64(v)
+			} else {
+				value = strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		case string:
+			if verb == "d" {
+				verb = "s"
+			}
+		}
+
+		return escapeFileName(fmt.Sprintf("%"+width+precision+verb, value))
+	})
+}
+
+// templateNeedsMetadata tells if the template uses anything
+// else than the ID, and so can only be rendered after parsing
+func templateNeedsMetadata(template string) bool {
+	for _, m := range templatePattern.FindAllStringSubmatch(template, -1) {
+		if m[1] != "%" && m[2] != "id" {
+			return true
+		}
+	}
+	return false
+}
+
+// dirTemplateIsPerVideo tells if every video gets its own directory,
+// that is if the last directory is named after the full ID
+func dirTemplateIsPerVideo() bool {
+	dirs := strings.Split(arguments.DirTemplate, "/")
+	for _, m := range templatePattern.FindAllStringSubmatch(dirs[len(dirs)-1], -1) {
+		if m[2] == "id" && m[4] == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// renderPaths defines the directory and the base file name of the video
+func renderPaths(video *Video) {
+	fields := templateFields(video)
+
+	dirs := strings.Split(renderTemplate(arguments.DirTemplate, fields), "/")
+	for i, dir := range dirs {
+		dirs[i] = sanitizeFileName(dir, maxFileNameBytes)
+	}
+	video.Path = arguments.Output + "/" + strings.Join(dirs, "/") + "/"
+	video.FileName = sanitizeFileName(renderTemplate(arguments.FileTemplate, fields), maxBaseFileNameBytes)
+}
@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestRenderTemplate(t *testing.T) {
+	fields := map[string]interface{}{
+		"id":         "MPBfVp0tB8E",
+		"title":      "A_title",
+		"uploader":   "AC/DC",
+		"view_count": float64(1234),
+		"rating":     4.5,
+	}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{defaultDirTemplate, "M/MPB/MPBfVp0tB8E"},
+		{defaultFileTemplate, "MPBfVp0tB8E_A_title"},
+		{"%(uploader)s/%(id)s", "AC_DC" + nameHash("AC/DC") + "/MPBfVp0tB8E"},
+		{"%(view_count)d views", "1234 views"},
+		{"%(view_count)08d", "00001234"},
+		{"%(rating)s", "4.5"},
+		{"%(missing)s", "NA"},
+		{"100%% %(id).3s", "100% MPB"},
+	}
+
+	for _, test := range tests {
+		if got := renderTemplate(test.template, fields); got != test.want {
+			t.Errorf("renderTemplate(%q) = %q, want %q", test.template, got, test.want)
+		}
+	}
+}
+
+func TestDirTemplateIsPerVideo(t *testing.T) {
+	defer useFixtures(t)()
+
+	tests := map[string]bool{
+		defaultDirTemplate:              true,
+		"%(uploader)s/%(id)s":           true,
+		"%(id)s/%(upload_date)s":        false,
+		"%(id).3s":                      false,
+		"all":                           false,
+		"%(uploader)s/%(id)s_%(title)s": true,
+	}
+	for template, want := range tests {
+		arguments.DirTemplate = template
+		if got := dirTemplateIsPerVideo(); got != want {
+			t.Errorf("dirTemplateIsPerVideo(%q) = %v, want %v", template, got, want)
+		}
+	}
+}
+
+func TestTemplateNeedsMetadata(t *testing.T) {
+	if templateNeedsMetadata(defaultDirTemplate) {
+		t.Errorf("%q only needs the ID", defaultDirTemplate)
+	}
+	if !templateNeedsMetadata(defaultFileTemplate) {
+		t.Errorf("%q needs the title", defaultFileTemplate)
+	}
+}
@@ -0,0 +1,27 @@
+var _yt_player={};(function(g){var window=this;/*
+
+ Copyright The Closure Library Authors.
+ SPDX-License-Identifier: Apache-2.0
+*/
+'use strict';var ba,ca,da,ea;ba=function(a){var b=0;return function(){return b<a.length?{done:!1,value:a[b++]}:{done:!0}}};
+ca="function"==typeof Object.defineProperties?Object.defineProperty:function(a,b,c){if(a==Array.prototype||a==Object.prototype)return a;a[b]=c.value;return a};
+da=function(a){a=["object"==typeof globalThis&&globalThis,a,"object"==typeof window&&window,"object"==typeof self&&self,"object"==typeof global&&global];for(var b=0;b<a.length;++b){var c=a[b];if(c&&c.Math==Math)return c}throw Error("Cannot find global object");};
+ea=da(this);var Lx="undefined";
+var aL={Xk:function(a,b){a.splice(0,b)},
+R6:function(a){a.reverse()},
+h4:function(a,b){var c=a[0];a[0]=a[b%a.length];a[b%a.length]=c}};
+var lma=function(a){a=a.split("");aL.R6(a,64);aL.h4(a,1);aL.Xk(a,1);aL.h4(a,35);aL.R6(a,5);return a.join("")};
+var mma=function(a,b){if("}"==b)return"{";return a.replace(/[{}]/g,function(c){return c=="{"?"}":"{"})};
+var vqa=function(a){var b=a.split(a.slice(0,0)),c=[-1886419296,"}",function(d,e){e=(e%d.length+d.length)%d.length;d.splice(-e).reverse().forEach(function(f){d.unshift(f)})},
+"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_",function(d,e,f){var h=f.length;d.forEach(function(l,m,n){this.push(n[m]=f[(f.indexOf(l)-f.indexOf(this[m])+m+h--)%f.length])},e.split(""))},
+function(d){d.reverse()},function(d,e){e=(e%d.length+d.length)%d.length;var f=d[0];d[0]=d[e];d[e]=f}];
+if(typeof Lx==="undefined")return a;
+try{c[2](b,7),c[5](b),c[6](b,c[0]),c[4](b,"kX9",c[3]),c[2](b,-3),c[6](b,2)}catch(d){return"enhanced_except_"+d+"_"+a}return b.join("")};
+var BD=[vqa];
+g.Vz=function(a,b){this.j=a;this.K=b||{}};
+g.Vz.prototype.set=function(a,b){this.K[a]=b;return this};
+g.Vz.prototype.get=function(a){return this.K[a]||null};
+var Wz=function(a){var b=new g.Vz(a.url),c=a.s,d=a.sp||"signature";c&&b.set(d,encodeURIComponent(lma(decodeURIComponent(c))));return b};
+var Xz=function(a){var b,c=new g.Vz(a);(b=c.get("n"))&&(b=BD[0](b),c.set("n",b));return c};
+g.Yz=function(a){var b="{"+a+"}";return mma(b,"}")};
+})(_yt_player);
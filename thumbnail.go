@@ -1,25 +1,30 @@
 package main
 
 import (
+	"context"
 	"io"
-	"os"
 )
 
-func downloadThumbnail(video *Video) error {
+func downloadThumbnail(ctx context.Context, video *Video) error {
 	// create the file
-	out, err := os.Create(video.Path + video.ID + "_" + video.Title + ".jpg")
+	out, err := createFile(video, ".jpg")
 	if err != nil {
 		return err
 	}
 	defer out.Close()
 
 	// get the data
-	resp, err := getHttpClient().Get(video.Thumbnail)
+	resp, err := httpGet(ctx, video.Thumbnail)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	// don't save an error page as the thumbnail
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{URL: "the thumbnail", StatusCode: resp.StatusCode}
+	}
+
 	// write the body to file
 	_, err = io.Copy(out, resp.Body)
 	if err != nil {
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Reasons why a video can't be archived, recorded in its tombstone
+const (
+	unavailableRemoved       = "removed"
+	unavailableTerminated    = "terminated"
+	unavailableCopyright     = "copyright"
+	unavailablePrivate       = "private"
+	unavailableAgeGated      = "age_gated"
+	unavailableMembersOnly   = "members_only"
+	unavailableRegionBlocked = "region_blocked"
+	unavailableNotFound      = "not_found"
+	unavailableUnknown       = "unavailable"
+)
+
+// Reasons that don't depend on who asks for the video, the others may
+// go away with another account, another proxy or later on
+var permanentReasons = map[string]bool{
+	unavailableRemoved:    true,
+	unavailableTerminated: true,
+	unavailableCopyright:  true,
+	unavailablePrivate:    true,
+	unavailableNotFound:   true,
+}
+
+// Extension of the file recording why a video is unavailable
+const tombstoneExtension = ".tombstone.json"
+
+// Messages of YouTube identifying each reason, checked in order
+// against the lowercased reason and subreason of the page
+var unavailableMessages = []struct {
+	Reason   string
+	Messages []string
+}{
+	{unavailableCopyright, []string{"copyright"}},
+	{unavailableTerminated, []string{"terminated", "terms of service", "community guidelines"}},
+	{unavailableRemoved, []string{"removed by the uploader", "removed by the user", "deleted"}},
+	{unavailablePrivate, []string{"private"}},
+	{unavailableMembersOnly, []string{"members-only", "join this channel"}},
+	{unavailableAgeGated, []string{"confirm your age", "age-restricted", "inappropriate for some users"}},
+	{unavailableRegionBlocked, []string{"in your country", "not available in your region", "blocked it in your country"}},
+}
+
+// UnavailableError is returned when YouTube tells why
+// the video can't be watched, the video won't be archived
+type UnavailableError struct {
+	Reason  string
+	Status  string
+	Message string
+}
+
+func (e *UnavailableError) Error() string {
+	return "video is unavailable (" + e.Reason + "): " + strings.TrimSpace(e.Status+" "+e.Message)
+}
+
+// Permanent tells if the video is unavailable for everyone, and so
+// deserves a tombstone instead of being tried again some day
+func (e *UnavailableError) Permanent() bool {
+	return permanentReasons[e.Reason]
+}
+
+// Tombstone structure containing why and when a video was found unavailable
+type Tombstone struct {
+	ID          string    `json:"id"`
+	Reason      string    `json:"reason"`
+	Status      string    `json:"status,omitempty"`
+	Message     string    `json:"message,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	ToolVersion string    `json:"tool_version"`
+}
+
+// newUnavailableError finds the reason from the playability status and
+// the messages displayed instead of the player
+func newUnavailableError(status string, messages ...string) *UnavailableError {
+	message := strings.TrimSpace(strings.Join(messages, " "))
+	lower := strings.ToLower(message)
+
+	err := &UnavailableError{Reason: unavailableUnknown, Status: status, Message: message}
+	for _, candidate := range unavailableMessages {
+		for _, m := range candidate.Messages {
+			if strings.Contains(lower, m) {
+				err.Reason = candidate.Reason
+				return err
+			}
+		}
+	}
+
+	switch status {
+	case "AGE_VERIFICATION_REQUIRED", "AGE_CHECK_REQUIRED", "CONTENT_CHECK_REQUIRED":
+		err.Reason = unavailableAgeGated
+	}
+	return err
+}
+
+// parseUnavailableMessage returns the error displayed by the legacy
+// watch page instead of the player, or nil if there is none
+func parseUnavailableMessage(document *goquery.Document) *UnavailableError {
+	message := strings.TrimSpace(document.Find("#unavailable-message").First().Text())
+	if message == "" {
+		return nil
+	}
+	submessage := strings.TrimSpace(document.Find("#unavailable-submessage").First().Text())
+	return newUnavailableError("", message, submessage)
+}
+
+// tombstoneDir returns where the tombstone of the video goes, its
+// directory when the template only depends on the ID
+func tombstoneDir(video *Video) string {
+	template := arguments.DirTemplate
+	if templateNeedsMetadata(template) {
+		template = defaultDirTemplate
+	}
+	return arguments.Output + "/" + renderTemplate(template, map[string]interface{}{"id": video.ID}) + "/"
+}
+
+// writeTombstone records why the video is unavailable, next to
+// what may already be archived for it
+func writeTombstone(video *Video, unavailable *UnavailableError) error {
+	tombstone := Tombstone{
+		ID:          video.ID,
+		Reason:      unavailable.Reason,
+		Status:      unavailable.Status,
+		Message:     unavailable.Message,
+		Timestamp:   time.Now().UTC(),
+		ToolVersion: version,
+	}
+
+	JSON, err := JSONMarshalIndentNoEscapeHTML(&tombstone, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := tombstoneDir(video)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(dir+video.ID+tombstoneExtension, JSON, 0644)
+}
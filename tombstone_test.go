@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestNewUnavailableError(t *testing.T) {
+	tests := []struct {
+		status   string
+		messages []string
+		want     string
+	}{
+		{"ERROR", []string{"Video unavailable", "This video is no longer available due to a copyright claim by Someone"}, unavailableCopyright},
+		{"ERROR", []string{"Video unavailable", "This video is no longer available because the YouTube account associated with this video has been terminated."}, unavailableTerminated},
+		{"ERROR", []string{"This video has been removed for violating YouTube's Terms of Service."}, unavailableTerminated},
+		{"ERROR", []string{"This video has been removed by the uploader"}, unavailableRemoved},
+		{"LOGIN_REQUIRED", []string{"Private video", "Sign in if you've been granted access to this video"}, unavailablePrivate},
+		{"LOGIN_REQUIRED", []string{"Sign in to confirm your age"}, unavailableAgeGated},
+		{"AGE_VERIFICATION_REQUIRED", nil, unavailableAgeGated},
+		{"LOGIN_REQUIRED", []string{"Join this channel to get access to members-only content like this video, and other exclusive perks."}, unavailableMembersOnly},
+		{"UNPLAYABLE", []string{"The uploader has not made this video available in your country."}, unavailableRegionBlocked},
+		{"ERROR", []string{"Video unavailable"}, unavailableUnknown},
+	}
+
+	for _, test := range tests {
+		if got := newUnavailableError(test.status, test.messages...); got.Reason != test.want {
+			t.Errorf("%s %q: reason = %s, want %s", test.status, test.messages, got.Reason, test.want)
+		}
+	}
+}
+
+func TestUnavailableErrorPermanent(t *testing.T) {
+	tests := map[string]bool{
+		unavailableRemoved:       true,
+		unavailableTerminated:    true,
+		unavailableCopyright:     true,
+		unavailablePrivate:       true,
+		unavailableNotFound:      true,
+		unavailableAgeGated:      false,
+		unavailableMembersOnly:   false,
+		unavailableRegionBlocked: false,
+		unavailableUnknown:       false,
+	}
+
+	for reason, want := range tests {
+		if got := (&UnavailableError{Reason: reason}).Permanent(); got != want {
+			t.Errorf("%s: Permanent() = %v, want %v", reason, got, want)
+		}
+	}
+}
+
+func TestParseUnavailableMessage(t *testing.T) {
+	page := `<html><body><h1 id="unavailable-message" class="message">
+		This video is private.
+	</h1><div id="unavailable-submessage">Sorry about that.</div></body></html>`
+	document, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unavailable := parseUnavailableMessage(document)
+	if unavailable == nil || unavailable.Reason != unavailablePrivate {
+		t.Errorf("got %v", unavailable)
+	}
+
+	document, _ = goquery.NewDocumentFromReader(bytes.NewReader([]byte("<html></html>")))
+	if unavailable := parseUnavailableMessage(document); unavailable != nil {
+		t.Errorf("got %v for an available video", unavailable)
+	}
+}
+
+func TestWriteTombstone(t *testing.T) {
+	defer useFixtures(t)()
+
+	video := &Video{ID: "MPBfVp0tB8E"}
+	if err := writeTombstone(video, newUnavailableError("ERROR", "This video has been removed by the uploader")); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(arguments.Output, "M", "MPB", video.ID, video.ID+tombstoneExtension)
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0644 {
+		t.Fatalf("tombstone file: %v, %v", info, err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tombstone Tombstone
+	if err := json.Unmarshal(data, &tombstone); err != nil {
+		t.Fatal(err)
+	}
+	if tombstone.ID != video.ID || tombstone.Reason != unavailableRemoved || tombstone.Timestamp.IsZero() {
+		t.Errorf("tombstone = %+v", tombstone)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// tlsConfig verifies the certificates against the system roots, and the
+// CA bundle given with --ca-bundle, it's nil when there is no bundle
+var tlsConfig *tls.Config
+
+// loadCABundle returns the TLS configuration trusting the certificates
+// of the PEM file on top of the system ones
+func loadCABundle(path string) (*tls.Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificate found in " + path)
+	}
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+// TransportOptions structure containing how the connections to
+// each host are pooled, and how long they may take
+type TransportOptions struct {
+	MaxConnsPerHost       int
+	MaxIdleConnsPerHost   int
+	IdleConnTimeout       time.Duration
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	// how long host names are cached, 0 to resolve them every time
+	DNSCacheTTL time.Duration
+}
+
+var transportOptions = TransportOptions{
+	MaxConnsPerHost:       16,
+	MaxIdleConnsPerHost:   16,
+	IdleConnTimeout:       90 * time.Second,
+	DialTimeout:           30 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: 30 * time.Second,
+}
+
+// resolver caches the host names for --dns-cache, shared by
+// every transport, nil when they aren't cached
+var resolver *dnsCache
+
+// newTransport returns a transport tuned with the transport options,
+// dialing the hosts, or the proxy, directly
+func newTransport() *http.Transport {
+	dialer := &net.Dialer{Timeout: transportOptions.DialTimeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
+		MaxConnsPerHost:       transportOptions.MaxConnsPerHost,
+		MaxIdleConnsPerHost:   transportOptions.MaxIdleConnsPerHost,
+		IdleConnTimeout:       transportOptions.IdleConnTimeout,
+		TLSHandshakeTimeout:   transportOptions.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: transportOptions.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+	if resolver != nil {
+		transport.DialContext = resolver.dialer(dialer.DialContext)
+	}
+	return transport
+}
+
+// newDirectTransport returns the transport used without proxy
+func newDirectTransport() *http.Transport {
+	transport := newTransport()
+	transport.Proxy = http.ProxyFromEnvironment
+	return transport
+}
+
+// socksDialer dials through a SOCKS5 proxy, resolving the host
+// names locally for socks5 and on the proxy for socks5h
+type socksDialer struct {
+	Dialer        proxy.ContextDialer
+	RemoteResolve bool
+}
+
+func (d *socksDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if d.RemoteResolve {
+		return d.Dialer.DialContext(ctx, network, address)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) == nil {
+		host, err = lookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d.Dialer.DialContext(ctx, network, net.JoinHostPort(host, port))
+}
+
+// newProxyTransport returns the transport going through the proxy, the
+// certificates of the sites are still verified whatever the proxy is
+func newProxyTransport(u *url.URL) (*http.Transport, error) {
+	transport := newTransport()
+
+	switch u.Scheme {
+	case "http", "https":
+		// Credentials of the URL are sent as Proxy-Authorization
+		transport.Proxy = http.ProxyURL(u)
+		return transport, nil
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u.User != nil {
+			password, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: password}
+		}
+
+		dialer, err := proxy.SOCKS5("tcp", u.Host, auth, &net.Dialer{Timeout: transportOptions.DialTimeout})
+		if err != nil {
+			return nil, err
+		}
+		contextDialer, ok := dialer.(proxy.ContextDialer)
+		if !ok {
+			return nil, errors.New("SOCKS5 dialer of " + proxyName(u) + " doesn't support contexts")
+		}
+
+		transport.DialContext = (&socksDialer{
+			Dialer:        contextDialer,
+			RemoteResolve: u.Scheme == "socks5h",
+		}).DialContext
+		return transport, nil
+	}
+	return nil, errors.New("unsupported proxy scheme " + u.Scheme)
+}
+
+// proxyPort returns the port of the proxy, with the default of its scheme
+func proxyPort(u *url.URL) int {
+	if port, err := strconv.Atoi(u.Port()); err == nil {
+		return port
+	}
+	switch u.Scheme {
+	case "https":
+		return 443
+	case "socks5", "socks5h":
+		return 1080
+	}
+	return 80
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// socksServer is a SOCKS5 proxy requiring user:pass, connecting every
+// CONNECT to target and sending the requested host on hosts
+func socksServer(t *testing.T, target string, hosts chan<- string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSocks(conn, target, hosts)
+		}
+	}()
+	return listener
+}
+
+func serveSocks(conn net.Conn, target string, hosts chan<- string) {
+	defer conn.Close()
+	buf := make([]byte, 512)
+
+	// greeting, only username/password authentication is offered
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 2})
+
+	// username/password
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	user := make([]byte, buf[1])
+	io.ReadFull(conn, user)
+	io.ReadFull(conn, buf[:1])
+	password := make([]byte, buf[0])
+	io.ReadFull(conn, password)
+	if string(user) != "user" || string(password) != "pass" {
+		conn.Write([]byte{1, 1})
+		return
+	}
+	conn.Write([]byte{1, 0})
+
+	// CONNECT request
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		io.ReadFull(conn, buf[:4])
+		host = net.IP(buf[:4]).String()
+	case 3:
+		io.ReadFull(conn, buf[:1])
+		name := make([]byte, buf[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	default:
+		return
+	}
+	io.ReadFull(conn, buf[:2])
+	hosts <- net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2]))))
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func TestSocksProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	hosts := make(chan string, 1)
+	listener := socksServer(t, server.Listener.Addr().String(), hosts)
+	defer listener.Close()
+
+	tests := []struct {
+		scheme string
+		host   string
+	}{
+		// socks5 resolves the host name itself, socks5h lets the proxy do it
+		{"socks5", "127.0.0.1"},
+		{"socks5h", "localhost"},
+	}
+	for _, test := range tests {
+		u, err := parseProxy(test.scheme + "://user:pass@" + listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport, err := newProxyTransport(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := (&http.Client{Transport: transport}).Get("http://localhost:" + port + "/")
+		if err != nil {
+			t.Fatalf("%s: %s", test.scheme, err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusNoContent {
+			t.Errorf("%s: status %d", test.scheme, res.StatusCode)
+		}
+		if host := <-hosts; host != net.JoinHostPort(test.host, port) {
+			t.Errorf("%s: proxy asked for %s", test.scheme, host)
+		}
+	}
+
+	// wrong credentials are refused
+	u, _ := parseProxy("socks5://user:wrong@" + listener.Addr().String())
+	transport, _ := newProxyTransport(u)
+	if _, err := (&http.Client{Transport: transport}).Get("http://localhost:" + port + "/"); err == nil {
+		t.Error("request went through with wrong credentials")
+	}
+}
+
+func TestCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	defer func(config *tls.Config) { tlsConfig = config }(tlsConfig)
+
+	// the test certificate isn't trusted by default
+	tlsConfig = nil
+	if _, err := (&http.Client{Transport: newDirectTransport()}).Get(server.URL); err == nil {
+		t.Fatal("certificate accepted without the CA bundle")
+	}
+
+	// nor through a proxy
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hijacked, _, _ := w.(http.Hijacker).Hijack()
+		defer hijacked.Close()
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			return
+		}
+		defer upstream.Close()
+		hijacked.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
+		go io.Copy(upstream, hijacked)
+		io.Copy(hijacked, upstream)
+	}))
+	defer proxy.Close()
+	u, _ := url.Parse(proxy.URL)
+	transport, err := newProxyTransport(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&http.Client{Transport: transport}).Get(server.URL); err == nil {
+		t.Fatal("certificate accepted through the proxy")
+	}
+
+	dir, err := ioutil.TempDir("", "youtube-ma-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bundle := filepath.Join(dir, "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(bundle, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err = loadCABundle(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport, err = newProxyTransport(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, transport := range []*http.Transport{newDirectTransport(), transport} {
+		res, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err != nil {
+			t.Fatalf("certificate rejected with the CA bundle: %s", err)
+		}
+		res.Body.Close()
+	}
+
+	if _, err := loadCABundle(os.DevNull); err == nil {
+		t.Error("empty CA bundle accepted")
+	}
+}
+
+func TestSharedClientReusesConnections(t *testing.T) {
+	var connections int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&connections, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	defer func(tripper http.RoundTripper) { directTripper = tripper }(directTripper)
+	directTripper = newDirectTransport()
+
+	for i := 0; i < 10; i++ {
+		res, err := httpGet(context.Background(), server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+	if connections != 1 {
+		t.Errorf("%d connections for 10 requests", connections)
+	}
+	if getHttpClient(context.Background()) != getHttpClient(context.Background()) {
+		t.Error("getHttpClient() returned a new client")
+	}
+}
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	defer func(options TransportOptions) { transportOptions = options }(transportOptions)
+	transportOptions.ResponseHeaderTimeout = 50 * time.Millisecond
+
+	_, err := (&http.Client{Transport: newDirectTransport()}).Get(server.URL)
+	if err == nil {
+		t.Fatal("request didn't time out")
+	}
+	if classifyError(err) != failureTransient {
+		t.Errorf("timeout classified as %s", classifyError(err))
+	}
+}
@@ -8,22 +8,31 @@ import (
 	"io/ioutil"
 	"net/url"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
 )
 
 // Video structure containing all metadata for the video
 type Video struct {
-	ID          string
-	Title       string
-	Annotations string
-	Thumbnail   string
-	Description string
-	Path        string
-	RawHTML     string
-	STS         float64
-	InfoJSON    infoJSON
-	playerArgs  map[string]interface{}
-	RawFormats  []url.Values
+	ID               string
+	Title            string
+	Annotations      string
+	Thumbnail        string
+	Description      string
+	Path             string
+	StagingPath      string
+	FileName         string
+	Files            []Artifact
+	RawHTML          string
+	STS              float64
+	InfoJSON         infoJSON
+	FieldStatus      map[string]string
+	playerArgs       map[string]interface{}
+	initialData      interface{}
+	previousManifest *Manifest
+	previousInfo     map[string]interface{}
+	RawFormats       []url.Values
 }
 
 // Tracklist structure containing all subtitles tracks for the video
@@ -91,6 +100,10 @@ type Format struct {
 	QualityLabel string  `json:"quality_label,omitempty"`
 	Type         string  `json:"type"`
 	Size         string  `json:"size,omitempty"`
+
+	// ciphered signature of the URL, and its parameter
+	signature      string
+	signatureParam string
 }
 
 // JSONMarshalIndentNoEscapeHTML allow proper json formatting
@@ -103,41 +116,87 @@ func JSONMarshalIndentNoEscapeHTML(i interface{}, prefix string, indent string)
 	return buf.Bytes(), err
 }
 
-func genPath(video *Video) error {
-	if _, err := os.Stat(video.Path); os.IsNotExist(err) {
-		err = os.MkdirAll(video.Path, 0755)
-		if err != nil {
-			return err
-		}
+// writeFileAtomic writes data to path through a temporary file of the
+// same directory, so that path is either the old or the new file, never
+// a half-written one
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
 	}
-	return nil
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
 }
 
 func checkFiles(video *Video) error {
-	// Define path based on the video ID
-	firstLayer := video.ID[:1]
-	secondLayer := video.ID[:3]
-	video.Path = arguments.Output + "/" + firstLayer + "/" + secondLayer + "/" + video.ID + "/"
+	// Define paths based on the output templates
+	renderPaths(video)
+
+	// Check if the manifest lists files that are all there
+	// if not, the video has to be archived again
+	path := findManifest(video)
+	manifest, err := readManifest(path)
+	if os.IsNotExist(err) {
+		// Archived before manifests existed, or not at all
+		path, err = backfillManifest(video)
+		if err != nil {
+			return nil
+		}
+		manifest, err = readManifest(path)
+	}
+	if err != nil || manifest.ID != video.ID {
+		return nil
+	}
+	if checkManifest(video.Path, manifest) != nil {
+		return nil
+	}
 
-	// Check if the path contain at least 3 files
-	// if not, return an error
-	files, err := ioutil.ReadDir(video.Path)
-	if err == nil && len(files) >= 3 {
-		return errors.New("this video has already been archived")
+	// Archived before the index existed, add it so
+	// that next time its metadata isn't needed
+	if _, err := readIndex(video.ID); err != nil {
+		writeIndex(video.ID, path)
 	}
-	return nil
+	return errors.New("this video has already been archived")
+}
+
+// createFile creates a file of the video in its staging
+// directory and keeps track of it
+func createFile(video *Video, extension string) (*os.File, error) {
+	name := video.FileName + extension
+	f, err := os.Create(video.StagingPath + name)
+	if err != nil {
+		return nil, err
+	}
+	video.Files = append(video.Files, Artifact{Name: name, FetchedAt: time.Now().UTC()})
+	return f, nil
 }
 
 func writeFiles(video *Video) error {
 	// write description
-	descriptionFile, err := os.Create(video.Path + video.ID + "_" + video.Title + ".description")
+	descriptionFile, err := createFile(video, ".description")
 	if err != nil {
 		return err
 	}
 	defer descriptionFile.Close()
 
 	// write info json file
-	infoFile, err := os.Create(video.Path + video.ID + "_" + video.Title + ".info.json")
+	infoFile, err := createFile(video, ".info.json")
 	if err != nil {
 		return err
 	}
@@ -145,11 +204,25 @@ func writeFiles(video *Video) error {
 
 	fmt.Fprintf(descriptionFile, "%s", video.Description)
 
-	JSON, err := JSONMarshalIndentNoEscapeHTML(video.InfoJSON, "", "  ")
+	JSON, err := JSONMarshalIndentNoEscapeHTML(&video.InfoJSON, "", "  ")
 	if err != nil {
 		return err
 	}
 
 	fmt.Fprintf(infoFile, "%s", string(JSON))
+
+	// write the extraction status of every field
+	statusFile, err := createFile(video, ".status.json")
+	if err != nil {
+		return err
+	}
+	defer statusFile.Close()
+
+	JSON, err = JSONMarshalIndentNoEscapeHTML(video.FieldStatus, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(statusFile, "%s", string(JSON))
 	return nil
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "youtube-ma-atomic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file.json")
+	for _, test := range []struct {
+		data string
+		perm os.FileMode
+	}{{"old", 0600}, {"new", 0644}} {
+		if err := writeFileAtomic(path, []byte(test.data), test.perm); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.Mode().Perm() != test.perm {
+			t.Errorf("mode = %v, %v, want %s", info, err, test.perm)
+		}
+		if data, _ := ioutil.ReadFile(path); string(data) != test.data {
+			t.Errorf("content = %q, want %q", data, test.data)
+		}
+	}
+
+	// no temporary file is left behind, even on failure
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "file.json"), nil, 0644); err == nil {
+		t.Error("writing to a missing directory succeeded")
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("files = %v", files)
+	}
+}
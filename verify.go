@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/akamensky/argparse"
+)
+
+// fileNameIDPattern matches the base names starting with a video ID,
+// as given by the default file template
+var fileNameIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{11}(_|$)`)
+
+// verifyJob structure containing where the files of a video are
+type verifyJob struct {
+	Dir  string
+	Base string
+}
+
+// VerifyReport structure containing the problems found with a video
+type VerifyReport struct {
+	ID       string
+	Dir      string
+	Base     string
+	Problems []string
+	// archived before manifests, its files can't be checked
+	Legacy bool
+}
+
+func (r *VerifyReport) addProblem(format string, a ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, a...))
+}
+
+// listVideos walks the output and sends every video it finds, that is
+// every base name having an info.json or a manifest
+func listVideos(root string, jobs chan<- verifyJob) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if info.Name() == stagingDirName || info.Name() == indexDirName {
+			return filepath.SkipDir
+		}
+
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			return err
+		}
+
+		bases := make(map[string]bool)
+		for _, file := range files {
+			for _, extension := range []string{manifestExtension, ".info.json"} {
+				if !file.IsDir() && strings.HasSuffix(file.Name(), extension) {
+					bases[strings.TrimSuffix(file.Name(), extension)] = true
+				}
+			}
+		}
+
+		var sorted []string
+		for base := range bases {
+			sorted = append(sorted, base)
+		}
+		sort.Strings(sorted)
+		for _, base := range sorted {
+			jobs <- verifyJob{Dir: path, Base: base}
+		}
+		return nil
+	})
+}
+
+// verifyJPEG makes sure the file decodes as a JPEG image
+func verifyJPEG(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = jpeg.Decode(f)
+	return err
+}
+
+// verifyXML makes sure the file is a well-formed XML document
+func verifyXML(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := xml.NewDecoder(f)
+	elements := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if _, ok := token.(xml.StartElement); ok {
+			elements++
+		}
+	}
+	if elements == 0 {
+		return errors.New("no XML element")
+	}
+	return nil
+}
+
+// verifyVideo checks the info.json, the manifest and the files of a video
+func verifyVideo(job verifyJob) VerifyReport {
+	report := VerifyReport{Dir: job.Dir, Base: job.Base}
+	prefix := filepath.Join(job.Dir, job.Base)
+
+	// the info.json has to parse
+	var info infoJSON
+	data, err := ioutil.ReadFile(prefix + ".info.json")
+	if err != nil {
+		report.addProblem("missing info.json")
+	} else if err = json.Unmarshal(data, &info); err != nil {
+		report.addProblem("info.json doesn't parse: %s", err)
+	}
+	report.ID = info.ID
+
+	// the manifest lists the files of a complete video with their
+	// checksums, without it we can only check the files we find
+	var names []string
+	manifest, err := readManifest(prefix + manifestExtension)
+	if err != nil {
+		// archive skips these videos and backfills their manifest,
+		// only a broken info.json makes them incomplete
+		if os.IsNotExist(err) && len(report.Problems) == 0 {
+			report.Legacy = true
+		} else {
+			report.addProblem("incomplete, no valid manifest: %s", err)
+		}
+
+		files, _ := ioutil.ReadDir(job.Dir)
+		for _, file := range files {
+			if strings.HasPrefix(file.Name(), job.Base+".") {
+				names = append(names, file.Name())
+			}
+		}
+	} else {
+		if report.ID == "" {
+			report.ID = manifest.ID
+		} else if manifest.ID != report.ID {
+			report.addProblem("manifest is for %s", manifest.ID)
+		}
+		if len(manifest.Files) == 0 {
+			report.addProblem("incomplete, no file listed in the manifest")
+		}
+
+		for _, artifact := range manifest.Files {
+			names = append(names, artifact.Name)
+			size, sum, err := hashFile(filepath.Join(job.Dir, artifact.Name))
+			if err != nil {
+				report.addProblem("%s: %s", artifact.Name, err)
+			} else if size != artifact.Size || sum != artifact.SHA256 {
+				report.addProblem("%s: checksum mismatch", artifact.Name)
+			}
+		}
+	}
+
+	// the thumbnail and the subtitles have to be readable
+	for _, name := range names {
+		path := filepath.Join(job.Dir, name)
+		switch filepath.Ext(name) {
+		case ".jpg":
+			err = verifyJPEG(path)
+		case ".xml":
+			err = verifyXML(path)
+		default:
+			continue
+		}
+		if err != nil && !os.IsNotExist(err) {
+			report.addProblem("%s: %s", name, err)
+		}
+	}
+
+	// the directory can be shared by several videos, only the
+	// default file names are known to start with the ID
+	if report.ID == "" && fileNameIDPattern.MatchString(job.Base) {
+		report.ID = job.Base[:11]
+	}
+	if report.ID == "" {
+		report.addProblem("unknown video ID")
+	}
+	return report
+}
+
+// runVerify audits the archive tree given after the verify command, the
+// broken or incomplete videos are printed on stdout and can be requeued
+func runVerify(args []string) int {
+	parser := argparse.NewParser("YouTube-MA verify", "Check every video of an output directory, "+
+		"printing the IDs of the broken or incomplete ones")
+
+	// The output directory comes first
+	dirs, args := splitInputs(args)
+
+	concurrency := parser.Int("j", "concurrency", &argparse.Options{
+		Required: false,
+		Help:     "Concurrency",
+		Default:  4})
+
+	requeue := parser.Flag("r", "requeue", &argparse.Options{
+		Required: false,
+		Help:     "Clear the manifest of broken videos so that they are archived again, and put them back in the queue given with --queue or --secret",
+		Default:  false})
+
+	queuePath := parser.String("q", "queue", &argparse.Options{
+		Required: false,
+		Help:     "Local queue file where to requeue the broken videos",
+		Default:  ""})
+
+	secret := parser.String("s", "secret", &argparse.Options{
+		Required: false,
+		Help:     "Secret youtube.the-eye.eu API key, to requeue the broken videos",
+		Default:  ""})
+
+	err := parser.Parse(args)
+	if err != nil {
+		fmt.Print(parser.Usage(err))
+		return 0
+	}
+
+	if len(dirs) != 1 {
+		fmt.Print(parser.Usage(errors.New("the output directory to verify is required, as in youtube-ma verify videos")))
+		return 0
+	}
+
+	var q WorkQueue
+	if *requeue && *secret != "" {
+		q = newEyeQueue(*secret)
+	} else if *requeue && *queuePath != "" {
+		q, err = openBoltQueue(*queuePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while opening the queue: %s\n", err.Error())
+			return 1
+		}
+	}
+	if q != nil {
+		defer q.Close()
+	}
+
+	jobs := make(chan verifyJob, inputChanSize)
+	reports := make(chan VerifyReport, outputChanSize)
+
+	go func() {
+		err := listVideos(dirs[0], jobs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while walking %s: %s\n", dirs[0], err.Error())
+		}
+		close(jobs)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				reports <- verifyVideo(job)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(reports)
+	}()
+
+	checked, broken, legacy := 0, 0, 0
+	for report := range reports {
+		checked++
+		if len(report.Problems) == 0 {
+			if report.Legacy {
+				legacy++
+				fmt.Fprintf(os.Stderr, "%s: legacy archive without manifest, unverified\n", filepath.Join(report.Dir, report.Base))
+			}
+			continue
+		}
+		broken++
+
+		for _, problem := range report.Problems {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", filepath.Join(report.Dir, report.Base), problem)
+		}
+		if report.ID == "" {
+			continue
+		}
+		fmt.Println(report.ID)
+
+		if !*requeue {
+			continue
+		}
+		err = clearManifest(filepath.Join(report.Dir, report.Base+manifestExtension), report.ID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while clearing the manifest of %s: %s\n", report.ID, err.Error())
+		}
+		if q != nil {
+			err = q.Requeue(context.Background(), report.ID)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error while requeuing %s: %s\n", report.ID, err.Error())
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "%d videos checked, %d broken or incomplete, %d legacy and unverified\n", checked, broken, legacy)
+	if broken > 0 {
+		return 1
+	}
+	return 0
+}
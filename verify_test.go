@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// listedVideos returns every video listVideos finds in the output
+func listedVideos(t *testing.T) []verifyJob {
+	jobs := make(chan verifyJob, 16)
+	if err := listVideos(arguments.Output, jobs); err != nil {
+		t.Fatal(err)
+	}
+	close(jobs)
+
+	var listed []verifyJob
+	for job := range jobs {
+		listed = append(listed, job)
+	}
+	return listed
+}
+
+func TestVerifyVideo(t *testing.T) {
+	defer useFixtures(t)()
+
+	for _, ID := range []string{legacyFixtureID, modernFixtureID} {
+		if err := archiveID(context.Background(), ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a leftover of a crashed run isn't part of the archive
+	leftover := filepath.Join(stagingRoot(), "leftover-1", "leftover.info.json")
+	os.MkdirAll(filepath.Dir(leftover), 0755)
+	ioutil.WriteFile(leftover, []byte("{"), 0644)
+
+	listed := listedVideos(t)
+	if len(listed) != 2 {
+		t.Fatalf("listed %+v", listed)
+	}
+	for _, job := range listed {
+		if report := verifyVideo(job); len(report.Problems) != 0 {
+			t.Errorf("%s: unexpected problems %q", report.ID, report.Problems)
+		}
+	}
+
+	// corrupt the thumbnail and a subtitle of the first video,
+	// and remove the manifest of the second one
+	prefix := filepath.Join(listed[0].Dir, listed[0].Base)
+	ioutil.WriteFile(prefix+".jpg", []byte("not a jpeg"), 0644)
+	ioutil.WriteFile(prefix+".en.xml", []byte("<transcript><text>"), 0644)
+	os.Remove(filepath.Join(listed[1].Dir, listed[1].Base+manifestExtension))
+
+	report := verifyVideo(listed[0])
+	// 2 checksums, the thumbnail and the subtitle
+	if len(report.Problems) != 4 {
+		t.Errorf("%s: problems = %q", report.ID, report.Problems)
+	}
+
+	// without its manifest, the second one looks archived by an older version
+	report = verifyVideo(listed[1])
+	if len(report.Problems) != 0 || !report.Legacy || report.ID == "" {
+		t.Errorf("%s: problems = %q, legacy = %t", report.ID, report.Problems, report.Legacy)
+	}
+}
+
+func TestVerifyLegacy(t *testing.T) {
+	defer useFixtures(t)()
+
+	if err := archiveID(context.Background(), legacyFixtureID); err != nil {
+		t.Fatal(err)
+	}
+	listed := listedVideos(t)
+	if len(listed) != 1 {
+		t.Fatalf("listed %+v", listed)
+	}
+	manifest := filepath.Join(listed[0].Dir, listed[0].Base+manifestExtension)
+	os.Remove(manifest)
+
+	queuePath, cleanup := tempBoltQueue(t)
+	defer cleanup()
+	if status := runVerify([]string{"verify", arguments.Output, "--requeue", "--queue", queuePath}); status != 0 {
+		t.Errorf("exit status = %d", status)
+	}
+
+	// legacy archives are left for archive to backfill
+	if _, err := os.Stat(manifest); !os.IsNotExist(err) {
+		t.Errorf("manifest of a legacy archive written: %v", err)
+	}
+	q, err := openBoltQueue(queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if IDs, err := q.Fetch(context.Background(), 10); err != io.EOF {
+		t.Errorf("requeued %q, %v", IDs, err)
+	}
+}
+
+func TestVerifyRequeue(t *testing.T) {
+	defer useFixtures(t)()
+	arguments.DirTemplate = "all"
+
+	for _, ID := range []string{legacyFixtureID, modernFixtureID} {
+		if err := archiveID(context.Background(), ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	listed := listedVideos(t)
+	if len(listed) != 2 {
+		t.Fatalf("listed %+v", listed)
+	}
+
+	// the info.json of the first video doesn't parse, its ID comes from
+	// the manifest, the second one loses both and only has its file name
+	first := filepath.Join(listed[0].Dir, listed[0].Base)
+	ioutil.WriteFile(first+".info.json", []byte("{"), 0644)
+	second := filepath.Join(listed[1].Dir, listed[1].Base)
+	ioutil.WriteFile(second+".info.json", []byte("{"), 0644)
+	os.Remove(second + manifestExtension)
+
+	for i, job := range listed {
+		if report := verifyVideo(job); report.ID != job.Base[:11] {
+			t.Errorf("video %d: ID = %q, want %q", i, report.ID, job.Base[:11])
+		}
+	}
+
+	queuePath, cleanup := tempBoltQueue(t)
+	defer cleanup()
+	if status := runVerify([]string{"verify", arguments.Output, "--requeue", "--queue", queuePath}); status != 1 {
+		t.Errorf("exit status = %d", status)
+	}
+
+	q, err := openBoltQueue(queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	IDs, err := q.Fetch(context.Background(), 10)
+	if err != nil || len(IDs) != 2 || IDs[0] == IDs[1] {
+		t.Fatalf("requeued %q, %v", IDs, err)
+	}
+	for _, ID := range IDs {
+		if ID != legacyFixtureID && ID != modernFixtureID {
+			t.Errorf("requeued %q", ID)
+		}
+	}
+
+	// the first video isn't taken as archived anymore
+	manifest, err := readManifest(first + manifestExtension)
+	if err != nil || len(manifest.Files) != 0 {
+		t.Errorf("manifest after requeuing = %+v, %v", manifest, err)
+	}
+}